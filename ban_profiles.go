@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+/**
+ * Folder with the user's ban prevention profiles, inside the config dir
+ */
+const ban_profiles_dir string = "ban_profiles"
+
+/**
+ * Built-in profile used when none is chosen
+ */
+const default_ban_profile string = "default"
+
+/**
+ * Hosts files Atmosphère reads: one for each kind of MMC, default.txt for the one without its own
+ */
+var hosts_files = []string{"default.txt", "emummc.txt", "sysmmc.txt"}
+
+var ban_profile_name_re = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+/**
+ * Set of ban prevention files written along with Atmosphère
+ */
+type BanProfile struct {
+	Description string `json:"description"`
+	// Contents of exosphere.ini
+	Exosphere string `json:"exosphere"`
+	// Contents of the files in atmosphere/hosts, by file name
+	Hosts map[string]string `json:"hosts"`
+	// File name without extension for the user's profiles
+	name    string
+	builtin bool
+}
+
+/**
+ * @param  []byte data Deflated
+ * @return string
+ */
+func inflate(data []byte) string {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	// The blobs are embedded, they can't be broken
+	inflated, _ := io.ReadAll(r)
+
+	return string(inflated)
+}
+
+/**
+ * Makes an exosphere.ini from the embedded one with PRODINFO blanked where asked
+ * @param  bool sysmmc
+ * @param  bool emummc
+ * @return string
+ */
+func exosphereIni(sysmmc bool, emummc bool) string {
+	ini := parseIni([]byte(inflate(compressed_exo)))
+
+	value := func(on bool) string {
+		if on {
+			return "1"
+		}
+		return "0"
+	}
+
+	s := ini.ensureSection("exosphere")
+	s.set("blank_prodinfo_sysmmc", value(sysmmc))
+	s.set("blank_prodinfo_emummc", value(emummc))
+
+	return string(ini.bytes())
+}
+
+/**
+ * Profiles shipped with the program, the default one has the files it always wrote
+ * @return []*BanProfile
+ */
+func builtinBanProfiles() []*BanProfile {
+	hosts := inflate(compressed_hosts)
+
+	return []*BanProfile{
+		{
+			name:        default_ban_profile,
+			builtin:     true,
+			Description: "Blank PRODINFO on emuMMC, Nintendo servers blocked on both",
+			Exosphere:   inflate(compressed_exo),
+			Hosts:       map[string]string{"default.txt": hosts},
+		},
+		{
+			name:        "emummc-only",
+			builtin:     true,
+			Description: "Blank PRODINFO and Nintendo servers blocked on emuMMC, sysMMC left online",
+			Exosphere:   exosphereIni(false, true),
+			Hosts:       map[string]string{"emummc.txt": hosts},
+		},
+		{
+			name:        "sysmmc-only",
+			builtin:     true,
+			Description: "Blank PRODINFO and Nintendo servers blocked on sysMMC, emuMMC left online",
+			Exosphere:   exosphereIni(true, false),
+			Hosts:       map[string]string{"sysmmc.txt": hosts},
+		},
+	}
+}
+
+/**
+ * Gets the folder of the user's profiles, it's not created
+ * @return string, error
+ */
+func banProfilesDir() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ban_profiles_dir), nil
+}
+
+/**
+ * Lists the built-in profiles followed by the user's ones
+ * @return []*BanProfile Sorted by name after the built-in ones, even if there's an error
+ * @return error         Profiles that couldn't be read, the rest are still listed
+ */
+func loadBanProfiles() ([]*BanProfile, error) {
+	profiles := builtinBanProfiles()
+
+	dir, err := banProfilesDir()
+	if err != nil {
+		return profiles, err
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	slices.Sort(matches)
+
+	var errs []error
+
+	for _, match := range matches {
+		p, err := readBanProfile(match)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", filepath.Base(match), err))
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+
+	return profiles, errors.Join(errs...)
+}
+
+/**
+ * @param  string file_path
+ * @return *BanProfile, error
+ */
+func readBanProfile(file_path string) (*BanProfile, error) {
+	data, err := os.ReadFile(file_path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &BanProfile{name: strings.TrimSuffix(filepath.Base(file_path), ".json")}
+	if err = jsoniter.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err = p.check(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+/**
+ * Finds a profile by name, built-in or the user's
+ * @param  string name
+ * @return *BanProfile, error
+ */
+func findBanProfile(name string) (*BanProfile, error) {
+	for _, p := range builtinBanProfiles() {
+		if p.name == name {
+			return p, nil
+		}
+	}
+
+	dir, err := banProfilesDir()
+	if err != nil {
+		return nil, err
+	}
+	if !ban_profile_name_re.MatchString(name) {
+		return nil, fmt.Errorf("unknown ban prevention profile %s", name)
+	}
+
+	p, err := readBanProfile(filepath.Join(dir, name+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unknown ban prevention profile %s", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("ban prevention profile %s: %s", name, err)
+	}
+
+	return p, nil
+}
+
+/**
+ * @return error Why the profile can't be used
+ */
+func (p *BanProfile) check() error {
+	if !ban_profile_name_re.MatchString(p.name) {
+		return errors.New("names can only have letters, numbers, - and _")
+	}
+
+	if strings.TrimSpace(p.Exosphere) != "" {
+		if parseIni([]byte(p.Exosphere)).section("exosphere") == nil {
+			return errors.New("exosphere.ini has no [exosphere] section")
+		}
+	}
+
+	for name := range p.Hosts {
+		if !slices.Contains(hosts_files, name) {
+			return fmt.Errorf("unknown hosts file %s, must be one of %s", name, strings.Join(hosts_files, ", "))
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Saves a user profile into the config dir, built-in names can't be used
+ * @return error
+ */
+func (p *BanProfile) save() error {
+	for _, builtin := range builtinBanProfiles() {
+		if p.name == builtin.name {
+			return fmt.Errorf("%s is a built-in profile, choose another name", p.name)
+		}
+	}
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	dir, err := banProfilesDir()
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(dir, os.ModePerm)
+
+	data, err := jsoniter.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, p.name+".json"), data, 0644)
+}
+
+/**
+ * Deletes a user profile
+ * @return error
+ */
+func (p *BanProfile) remove() error {
+	if p.builtin {
+		return fmt.Errorf("%s is a built-in profile", p.name)
+	}
+
+	dir, err := banProfilesDir()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filepath.Join(dir, p.name+".json"))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+/**
+ * Shows a dialog to view the ban prevention profiles, choose the one used for the builds and save
+ * edited copies as the user's own profiles
+ * @param *Settings   settings
+ * @param fyne.Window w
+ */
+func showBanProfilesDialog(settings *Settings, w fyne.Window) {
+	profiles, err := loadBanProfiles()
+	if err != nil {
+		dialog.ShowError(err, w)
+	}
+
+	description := widget.NewLabel("")
+	description.Wrapping = fyne.TextWrapWord
+
+	exosphere := widget.NewMultiLineEntry()
+	tabs := container.NewAppTabs(container.NewTabItem("exosphere.ini", exosphere))
+
+	hosts := map[string]*widget.Entry{}
+	for _, name := range hosts_files {
+		hosts[name] = widget.NewMultiLineEntry()
+		hosts[name].SetPlaceHolder("Not written if empty")
+		tabs.Append(container.NewTabItem(name, hosts[name]))
+	}
+
+	var current *BanProfile
+
+	use_btn := widget.NewButton("Use", nil)
+	delete_btn := widget.NewButton("Delete", nil)
+
+	names := []string{}
+	for _, p := range profiles {
+		names = append(names, p.name)
+	}
+	profile_sel := widget.NewSelect(names, func(name string) {
+		for _, p := range profiles {
+			if p.name != name {
+				continue
+			}
+			current = p
+			description.SetText(p.Description)
+			exosphere.SetText(p.Exosphere)
+			for hosts_name, entry := range hosts {
+				entry.SetText(p.Hosts[hosts_name])
+			}
+		}
+
+		if current.name == settings.banProfile() {
+			use_btn.Disable()
+		} else {
+			use_btn.Enable()
+		}
+		if current.builtin {
+			delete_btn.Disable()
+		} else {
+			delete_btn.Enable()
+		}
+	})
+
+	use_btn.OnTapped = func() {
+		settings.BanProfile = current.name
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		use_btn.Disable()
+	}
+
+	delete_btn.OnTapped = func() {
+		dialog.ShowConfirm("Delete profile", "Delete "+current.name+"?", func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := current.remove(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			// Builds go back to the default files
+			if settings.BanProfile == current.name {
+				settings.BanProfile = ""
+				settings.save()
+			}
+
+			for i, p := range profiles {
+				if p == current {
+					profiles = append(profiles[:i], profiles[i+1:]...)
+					profile_sel.Options = append(profile_sel.Options[:i], profile_sel.Options[i+1:]...)
+					break
+				}
+			}
+			profile_sel.SetSelected(default_ban_profile)
+		}, w)
+	}
+
+	save_btn := widget.NewButton("Save as…", func() {
+		name := widget.NewEntry()
+		if !current.builtin {
+			name.SetText(current.name)
+		}
+		desc := widget.NewEntry()
+		desc.SetText(current.Description)
+
+		dialog.ShowForm("Save profile", "Save", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Name", name),
+			widget.NewFormItem("Description", desc),
+		}, func(ok bool) {
+			if !ok {
+				return
+			}
+
+			p := &BanProfile{name: name.Text, Description: desc.Text, Exosphere: exosphere.Text, Hosts: map[string]string{}}
+			for hosts_name, entry := range hosts {
+				if entry.Text != "" {
+					p.Hosts[hosts_name] = entry.Text
+				}
+			}
+			if err := p.save(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			// Replaces the one with the same name
+			replaced := false
+			for i := range profiles {
+				if profiles[i].name == p.name {
+					profiles[i] = p
+					replaced = true
+				}
+			}
+			if !replaced {
+				profiles = append(profiles, p)
+				profile_sel.Options = append(profile_sel.Options, p.name)
+			}
+			profile_sel.SetSelected(p.name)
+			// Same name doesn't trigger OnChanged
+			current = p
+			delete_btn.Enable()
+		}, w)
+	})
+
+	profile_sel.SetSelected(settings.banProfile())
+	if current == nil {
+		// The chosen one is gone
+		profile_sel.SetSelected(default_ban_profile)
+	}
+
+	top := container.NewVBox(
+		container.NewBorder(nil, nil, widget.NewLabel("Profile"), container.NewHBox(use_btn, save_btn, delete_btn), profile_sel),
+		description,
+	)
+
+	d := dialog.NewCustom("Ban prevention", "Close", container.NewBorder(top, nil, nil, nil, tabs), w)
+	d.Resize(fyne.NewSize(600, 480))
+	d.Show()
+}
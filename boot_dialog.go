@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+/**
+ * Loader choice of stock entries that don't load Atmosphère's package
+ */
+const boot_no_loader string = "none"
+
+/**
+ * Widgets of one boot entry in the boot dialog
+ */
+type boot_row struct {
+	kind      *widget.Select
+	name      *widget.Entry
+	loader    *widget.Select
+	kip1patch *widget.Check
+	icon      *widget.Entry
+}
+
+/**
+ * Makes the widgets of a boot entry
+ * @param  BootEntry e
+ * @return *boot_row
+ */
+func newBootRow(e BootEntry) *boot_row {
+	row := &boot_row{
+		name:      widget.NewEntry(),
+		loader:    widget.NewSelect(nil, nil),
+		kip1patch: widget.NewCheck("kip1patch", nil),
+		icon:      widget.NewEntry(),
+	}
+
+	kind_labels := []string{}
+	for _, kind := range boot_kinds {
+		kind_labels = append(kind_labels, bootKindLabel(kind))
+	}
+
+	// Only stock entries can go without a loader
+	row.kind = widget.NewSelect(kind_labels, func(label string) {
+		loaders := []string{loader_pkg3, loader_fss0}
+		if label == bootKindLabel(boot_stock) {
+			loaders = append([]string{boot_no_loader}, loaders...)
+		}
+		row.loader.Options = loaders
+		if !slices.Contains(loaders, row.loader.Selected) {
+			row.loader.SetSelected(loaders[0])
+		}
+		row.loader.Refresh()
+		row.name.SetPlaceHolder(label)
+	})
+
+	// Also picks the default loader of the kind
+	row.kind.SetSelected(bootKindLabel(e.Kind))
+	if e.Loader != "" {
+		row.loader.SetSelected(e.Loader)
+	}
+	row.name.SetText(e.Name)
+	row.kip1patch.Checked = e.Kip1patch
+	row.icon.SetText(e.Icon)
+	row.icon.SetPlaceHolder("Icon, e.g. bootloader/res/icon_payload.bmp")
+
+	return row
+}
+
+/**
+ * @return BootEntry As set in the widgets
+ */
+func (row *boot_row) entry() BootEntry {
+	e := BootEntry{Name: row.name.Text, Loader: row.loader.Selected, Kip1patch: row.kip1patch.Checked, Icon: row.icon.Text}
+	for _, kind := range boot_kinds {
+		if bootKindLabel(kind) == row.kind.Selected {
+			e.Kind = kind
+		}
+	}
+	if e.Loader == boot_no_loader {
+		e.Loader = ""
+	}
+	return e
+}
+
+/**
+ * Shows a dialog to edit the boot entries written into hekate_ipl.ini, saved when confirmed
+ * @param *Settings   settings
+ * @param fyne.Window w
+ */
+func showBootDialog(settings *Settings, w fyne.Window) {
+	rows := []*boot_row{}
+	list := container.NewVBox()
+	autoboot_sel := widget.NewSelect(nil, nil)
+
+	// Entries are picked for autoboot by their place in the list
+	var refresh func()
+	refresh = func() {
+		selected := autoboot_sel.SelectedIndex()
+		options := []string{"Off"}
+		for i := range rows {
+			options = append(options, "Entry "+strconv.Itoa(i+1))
+		}
+		autoboot_sel.Options = options
+		if selected < 0 || selected >= len(options) {
+			selected = 0
+		}
+		autoboot_sel.SetSelectedIndex(selected)
+
+		list.RemoveAll()
+		for i, row := range rows {
+			remove := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				rows = slices.Delete(rows, i, i+1)
+				// Later entries move up, the autoboot one with them
+				if at := autoboot_sel.SelectedIndex(); at == i+1 {
+					autoboot_sel.SetSelectedIndex(0)
+				} else if at > i+1 {
+					autoboot_sel.SetSelectedIndex(at - 1)
+				}
+				refresh()
+			})
+			list.Add(widget.NewLabel(fmt.Sprintf("Entry %d", i+1)))
+			list.Add(container.NewBorder(nil, nil,
+				container.NewHBox(row.kind, row.loader, row.kip1patch), remove,
+				container.NewGridWithColumns(2, row.name, row.icon)))
+		}
+	}
+
+	if settings.Boot != nil {
+		for _, e := range settings.Boot.Entries {
+			rows = append(rows, newBootRow(e))
+		}
+	}
+	refresh()
+	if settings.Boot != nil && settings.Boot.Autoboot <= len(rows) {
+		autoboot_sel.SetSelectedIndex(settings.Boot.Autoboot)
+	}
+
+	add_btn := widget.NewButtonWithIcon("Add entry", theme.ContentAddIcon(), func() {
+		rows = append(rows, newBootRow(BootEntry{Kind: boot_emummc, Loader: loader_pkg3}))
+		refresh()
+	})
+
+	content := container.NewBorder(nil,
+		container.NewVBox(add_btn, widget.NewForm(widget.NewFormItem("Autoboot", autoboot_sel))),
+		nil, nil, container.NewVScroll(list))
+
+	d := dialog.NewCustomConfirm("Boot entries", "Save", "Cancel", content, func(save bool) {
+		if !save {
+			return
+		}
+
+		boot := &BootConfig{Entries: []BootEntry{}, Autoboot: autoboot_sel.SelectedIndex()}
+		for _, row := range rows {
+			boot.Entries = append(boot.Entries, row.entry())
+		}
+
+		if err := boot.check(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		settings.Boot = boot
+		if len(boot.Entries) == 0 {
+			settings.Boot = nil
+		}
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+	d.Resize(fyne.NewSize(760, 480))
+	d.Show()
+}
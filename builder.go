@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+/**
+ * Runs the build pipeline and reports everything it does to a sink
+ */
+type Builder struct {
+	sink     EventSink
+	settings *Settings
+	// Overall progress of the running build, in steps
+	steps_done  atomic.Int64
+	steps_total int64
+	// Files of the running build that were already in the output dir
+	conflicts []conflict
+	// Files put into the output dir by the component being installed, with their SHA-256
+	written map[string]string
+	// Set while updating an SD card in place
+	update *update_state
+	// How each selected component of the running build ended up, in registry order
+	results []*ComponentResult
+}
+
+/**
+ * @param  EventSink sink     Where the build events go
+ * @param  *Settings settings Versions and other choices for the build
+ * @return *Builder
+ */
+func NewBuilder(sink EventSink, settings *Settings) *Builder {
+	return &Builder{sink: sink, settings: settings}
+}
+
+func (b *Builder) emit(e Event) {
+	b.sink.Emit(e)
+}
+
+func (b *Builder) info(component string, format string, a ...any) {
+	b.emit(Event{Kind: EventInfo, Component: component, Message: fmt.Sprintf(format, a...)})
+}
+
+func (b *Builder) warn(component string, format string, a ...any) {
+	b.emit(Event{Kind: EventWarning, Component: component, Message: fmt.Sprintf(format, a...)})
+}
+
+/**
+ * Starts an extract/copy/move step, must be followed by stepDone or warn
+ */
+func (b *Builder) step(component string, format string, a ...any) {
+	b.emit(Event{Kind: EventStep, Component: component, Message: fmt.Sprintf(format, a...)})
+}
+
+func (b *Builder) stepDone(component string) {
+	b.emit(Event{Kind: EventStep, Component: component, Done: true})
+}
+
+/**
+ * Sets how many steps the running build has, downloading and installing each component
+ * are a step each
+ */
+func (b *Builder) startSteps(total int) {
+	b.steps_total = int64(total)
+	b.steps_done.Store(0)
+	b.emit(Event{Kind: EventBuildProgress, Total: b.steps_total})
+}
+
+/**
+ * Marks a build step as done, safe to call while downloading in parallel
+ */
+func (b *Builder) stepsAdvance() {
+	b.emit(Event{Kind: EventBuildProgress, Received: b.steps_done.Add(1), Total: b.steps_total})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Release assets that are checksum lists, e.g. SHA256SUMS, checksums.txt or foo.zip.sha256
+ */
+var checksum_file_re = regexp.MustCompile(`(?i)(sha256sums?(\.txt)?|checksums?\.txt|\.sha256(sum)?)$`)
+
+var sha256_re = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+/**
+ * Gets the hex SHA-256 from an API digest field formatted as "sha256:{hex}"
+ * @param  string digest
+ * @return string Empty if it's not a SHA-256 digest
+ */
+func digestSha256(digest string) string {
+	algorithm, hash, found := strings.Cut(digest, ":")
+	if !found || !strings.EqualFold(algorithm, "sha256") || !sha256_re.MatchString(hash) {
+		return ""
+	}
+	return strings.ToLower(hash)
+}
+
+/**
+ * Downloads and parses a checksum file, lines are formatted as "{hex}  {filename}" like sha256sum
+ * does. Single-file lists like foo.zip.sha256 may contain just the hash
+ * @param  context.Context ctx
+ * @param  string          filename Name of the checksum file itself
+ * @param  string          url
+ * @return map[string]string SHA-256 for each file name, error
+ */
+func getChecksums(ctx context.Context, filename string, url string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Check server response
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status: %s", res.Status)
+	}
+
+	checksums := map[string]string{}
+
+	// For files with only a hash in them
+	single_name := filename[:len(filename)-len(checksum_file_re.FindString(filename))]
+
+	scanner := bufio.NewScanner(res.Body)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !sha256_re.MatchString(fields[0]) {
+			continue
+		}
+
+		name := single_name
+		if len(fields) > 1 {
+			// Binary mode marker
+			name = path.Base(strings.TrimPrefix(fields[1], "*"))
+		}
+		if name != "" {
+			checksums[name] = strings.ToLower(fields[0])
+		}
+	}
+
+	return checksums, scanner.Err()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/widget"
+)
+
+/**
+ * How many child check boxes go in each indented row
+ */
+const checks_per_row int = 2
+
+/**
+ * Creates the what-to-do check boxes from the components registry. Components
+ * depending on another one are shown indented below it, and hidden when it's unchecked
+ * @return []fyne.CanvasObject      Rows to be put in the checkboxes container
+ * @return map[string]binding.Bool  Check box state for each component id
+ * @return map[string]*widget.Check Check box of each component id
+ */
+func newComponentChecks() ([]fyne.CanvasObject, map[string]binding.Bool, map[string]*widget.Check) {
+	checks_data := map[string]binding.Bool{}
+	checks := map[string]*widget.Check{}
+
+	for _, c := range components {
+		data := binding.NewBool()
+		data.Set(c.checked)
+		checks_data[c.id] = data
+		checks[c.id] = widget.NewCheckWithData(c.label, data)
+	}
+
+	// Add semi-radio button behavior to conflicting checks
+	for _, c := range components {
+		data := checks_data[c.id]
+		conflicts := c.conflicts
+
+		data.AddListener(binding.NewDataListener(func() {
+			if checked, _ := data.Get(); !checked {
+				return
+			}
+			for _, id := range conflicts {
+				if other, ok := checks_data[id]; ok {
+					if checked, _ := other.Get(); checked {
+						other.Set(false)
+					}
+				}
+			}
+		}))
+	}
+
+	// Spacer text widget
+	emsps := canvas.NewText("  ", color.Transparent)
+
+	rows := []fyne.CanvasObject{}
+
+	for _, c := range components {
+		// Children are added along with their parent
+		if len(c.depends) > 0 && checks[c.depends[0]] != nil {
+			continue
+		}
+
+		rows = append(rows, checks[c.id])
+
+		// These can be hidden
+		var child_rows []*fyne.Container
+		var row *fyne.Container
+
+		for _, child := range components {
+			if len(child.depends) == 0 || child.depends[0] != c.id {
+				continue
+			}
+			if row == nil || len(row.Objects) > checks_per_row {
+				row = container.NewHBox(emsps)
+				child_rows = append(child_rows, row)
+				rows = append(rows, row)
+			}
+			row.Add(checks[child.id])
+		}
+
+		if len(child_rows) == 0 {
+			continue
+		}
+
+		data := checks_data[c.id]
+		data.AddListener(binding.NewDataListener(func() {
+			checked, _ := data.Get()
+			for _, row := range child_rows {
+				if checked {
+					row.Show()
+				} else {
+					row.Hide()
+				}
+			}
+		}))
+	}
+
+	return rows, checks_data, checks
+}
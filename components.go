@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/**
+ * Where a component's files come from
+ */
+type source_kind int
+
+const (
+	// Nothing to download, works on files already in the output dir
+	sourceNone source_kind = iota
+	// Latest GitHub release of repo
+	sourceGitHub
+	// Latest Gitea release of repo, api_url is mandatory
+	sourceGitea
+	// Plain file at url
+	sourceRaw
+	// Scraped from the SPs forum thread
+	sourceForum
+)
+
+/**
+ * What to do with a component's files
+ */
+type install_kind int
+
+const (
+	// Extract every zip file into dest, skipping entries starting with skip_prefix
+	installExtract install_kind = iota
+	// Copy the from file, already in the output dir, to dest
+	installCopy
+	// Move every downloaded file into the dest folder
+	installMove
+)
+
+/**
+ * Registry entry, everything the build needs to know about a component
+ */
+type component struct {
+	// Used for flags and for the selection
+	id string
+	// Shown in the log
+	name string
+	// Check box text
+	label string
+	// Checked by default
+	checked bool
+	// If it fails the whole build is aborted
+	required bool
+
+	source  source_kind
+	repo    string
+	api_url string
+	url     string
+	// Regex filter for the release assets
+	filter string
+
+	install     install_kind
+	skip_prefix string
+	from        string
+	// Relative to the output dir, a folder for extract and move, a file for copy
+	dest string
+	// Rename the moved file, only makes sense for a single file
+	dest_name string
+
+	// Ids of components that must be selected too
+	depends []string
+	// Ids of components that can't be selected at the same time
+	conflicts []string
+
+	// File that tells the component is on an SD card, slash-separated and relative to its root
+	detect string
+	// User-edited files that an update never replaces, a trailing "/" means a whole folder
+	configs []string
+	// Reads the installed version from an SD card, returns the version and extra details
+	read_version func(sd_root string) (string, string, error)
+
+	// Extra steps after installing, only errUnsafeEntries is returned, anything else is a warning
+	after func(ctx context.Context, b *Builder, outdir string) error
+}
+
+/**
+ * All known components, in download and install order
+ */
+var components = []*component{
+	{
+		id:           "atmosphere",
+		name:         "Atmosphère",
+		label:        "Atmosphère",
+		checked:      true,
+		required:     true,
+		source:       sourceGitHub,
+		repo:         "Atmosphere-NX/Atmosphere",
+		filter:       `\.zip$`,
+		install:      installExtract,
+		detect:       "atmosphere/package3",
+		configs:      []string{"exosphere.ini", "atmosphere/config/", "atmosphere/hosts/"},
+		after:        afterAtmosphere,
+		read_version: readAtmosphereVersion,
+	},
+	{
+		id:           "hekate",
+		name:         "Hekate",
+		label:        "Hekate",
+		checked:      true,
+		required:     true,
+		source:       sourceGitHub,
+		repo:         "CTCaer/hekate",
+		filter:       `hekate_ctcaer.+\.zip$`,
+		install:      installExtract,
+		skip_prefix:  "hekate_ctcaer",
+		detect:       "bootloader/update.bin",
+		configs:      []string{"bootloader/hekate_ipl.ini", "bootloader/nyx.ini", "bootloader/ini/"},
+		read_version: readHekateVersion,
+		after:        afterHekate,
+	},
+	{
+		id:        "payload",
+		name:      "Hekate payload.bin",
+		label:     "payload.bin from Hekate",
+		source:    sourceNone,
+		install:   installCopy,
+		from:      filepath.Join("bootloader", "update.bin"),
+		dest:      "payload.bin",
+		depends:   []string{"hekate"},
+		conflicts: []string{"bootdat"},
+		detect:    "payload.bin",
+	},
+	{
+		id:        "bootdat",
+		name:      "SX Gear boot files",
+		label:     "boot.dat from SX Gear",
+		source:    sourceRaw,
+		url:       "https://raw.githubusercontent.com/mondul/MakeNSWSD-GUI/main/sxgearboot.zip",
+		install:   installExtract,
+		depends:   []string{"hekate"},
+		conflicts: []string{"payload"},
+		detect:    "boot.dat",
+	},
+	{
+		id:        "lockpick",
+		name:      "Lockpick_RCM",
+		label:     "Lockpick_RCM",
+		source:    sourceGitea,
+		repo:      "Mirror/Lockpick_RCM",
+		api_url:   "git.gdm.rocks/api/v1",
+		filter:    `\.bin$`,
+		install:   installMove,
+		dest:      filepath.Join("bootloader", "payloads"),
+		dest_name: "Lockpick_RCM.bin",
+		depends:   []string{"hekate"},
+		detect:    "bootloader/payloads/Lockpick_RCM.bin",
+	},
+	{
+		id:      "sps",
+		name:    "SPs",
+		label:   "SPs",
+		checked: true,
+		source:  sourceForum,
+		install: installExtract,
+		detect:  "atmosphere/exefs_patches/es_patches",
+	},
+	{
+		id:           "dbi",
+		name:         "DBI",
+		label:        "DBI",
+		source:       sourceGitHub,
+		repo:         "rashevskyv/dbi",
+		filter:       `((dbi\.config)|(DBI\.nro))$`,
+		install:      installMove,
+		dest:         filepath.Join("switch", "DBI"),
+		detect:       "switch/DBI/DBI.nro",
+		configs:      []string{"switch/DBI/dbi.config"},
+		read_version: readDbiVersion,
+	},
+}
+
+/**
+ * Finds a component in the registry
+ * @param  string id
+ * @return *component Nil if not found
+ */
+func getComponent(id string) *component {
+	for _, c := range components {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+/**
+ * Ban prevention files and custom boot logo
+ * @param  context.Context ctx
+ * @param  *Builder        b
+ * @param  string          outdir
+ * @return error           errUnsafeEntries if the boot logo had rejected entries
+ */
+func afterAtmosphere(ctx context.Context, b *Builder, outdir string) error {
+	b.step("Atmosphère", "Creating ban prevention files (%s profile)", b.settings.banProfile())
+	report, err := b.preventBan(outdir)
+	if err != nil {
+		b.warn("Atmosphère", "Could not create files: %s", err)
+	} else {
+		b.stepDone("Atmosphère")
+	}
+	for _, line := range report {
+		b.info("Atmosphère", "%s", line)
+	}
+
+	// Extract bootlogo if found
+	boot_logo_zip := filepath.Join(workdir, "bootlogo.zip")
+	if _, err := os.Stat(boot_logo_zip); err == nil {
+		b.step("Atmosphère", "Extracting custom boot logo")
+		if err = b.extractZip(ctx, boot_logo_zip, filepath.Join(outdir, "atmosphere", "exefs_patches")); err != nil {
+			b.warn("Atmosphère", "Could not extract boot logo: %s", err)
+			if errors.Is(err, errUnsafeEntries) {
+				return err
+			}
+		} else {
+			b.stepDone("Atmosphère")
+		}
+	}
+
+	return nil
+}
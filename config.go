@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+/**
+ * Folder name used inside the user config dir
+ */
+const config_name string = "make-nsw-sd"
+
+/**
+ * Gets the user config folder for this program, it's not created
+ * @return string, error
+ */
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, config_name), nil
+}
+
+/**
+ * Gets the folder where the executable is
+ * @return string, error
+ */
+func exeDir() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(exe), nil
+}
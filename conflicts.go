@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+/**
+ * What to do when a file being written into the output dir is already there
+ */
+const (
+	conflict_overwrite string = "overwrite"
+	conflict_skip      string = "skip"
+	conflict_newer     string = "keep-newer"
+	conflict_backup    string = "backup"
+)
+
+var conflict_policies = []string{conflict_overwrite, conflict_skip, conflict_newer, conflict_backup}
+
+/**
+ * Describes a conflict policy for the log and the GUI
+ * @param  string policy
+ * @return string
+ */
+func conflictLabel(policy string) string {
+	switch policy {
+	case conflict_skip:
+		return "Keep existing files"
+	case conflict_newer:
+		return "Keep the newer file"
+	case conflict_backup:
+		return "Back up to .bak"
+	}
+	return "Overwrite"
+}
+
+/**
+ * File that was already in the output dir and what was done about it
+ */
+type conflict struct {
+	path   string
+	action string
+	// The file is renamed to .bak right before the new one takes its place
+	backup bool
+}
+
+/**
+ * Applies the conflict policy before writing a file into the output dir, only called from the
+ * install steps, which don't run in parallel
+ * @param  string    dst      Path of the file to write
+ * @param  time.Time modified When the new contents were last changed
+ * @return bool      False if the existing file must be left alone
+ * @return *conflict What's going to be done about the existing file, nil if there's none. Only
+ *                   reported once the new file is in place, see replaceFile
+ * @return error
+ */
+func (b *Builder) prepareWrite(dst string, modified time.Time) (bool, *conflict, error) {
+	info, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return true, nil, nil
+	} else if err != nil {
+		return false, nil, err
+	}
+	if info.IsDir() {
+		return false, nil, errors.New("there's a folder with the same name")
+	}
+
+	if b.update != nil {
+		if write, action, sha, decided := b.update.decide(dst); decided {
+			if !write {
+				b.conflicts = append(b.conflicts, conflict{path: dst, action: action})
+				b.wrote(dst, sha)
+			}
+			return write, nil, nil
+		}
+	}
+
+	existing := &conflict{path: dst}
+	write := true
+
+	switch b.settings.conflicts() {
+	case conflict_skip:
+		write, existing.action = false, "kept existing file"
+	case conflict_newer:
+		if modified.After(info.ModTime()) {
+			existing.action = "overwrote older file"
+		} else {
+			write, existing.action = false, "kept existing file, it's not older"
+		}
+	case conflict_backup:
+		existing.action, existing.backup = "backed up to "+filepath.Base(dst)+".bak", true
+	default:
+		existing.action = "overwrote"
+	}
+
+	if !write {
+		b.conflicts = append(b.conflicts, *existing)
+		b.wrote(dst, "")
+	}
+
+	return write, existing, nil
+}
+
+/**
+ * Puts a complete new file in place of dst, backing up the existing one first if the policy says
+ * so. The backup is put back if the new file can't take its place
+ * @param  string    src      Complete new file, renamed to dst
+ * @param  string    dst
+ * @param  *conflict existing As returned by prepareWrite
+ * @return error
+ */
+func (b *Builder) replaceFile(src string, dst string, existing *conflict) error {
+	backup := existing != nil && existing.backup
+
+	if backup {
+		os.Remove(dst + ".bak")
+		if err := os.Rename(dst, dst+".bak"); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		if backup {
+			os.Rename(dst+".bak", dst)
+		}
+		return err
+	}
+
+	if existing != nil {
+		b.conflicts = append(b.conflicts, *existing)
+	}
+
+	return nil
+}
+
+/**
+ * Writes a file into the output dir following the conflict policy. It's written to a temp file
+ * next to it first, so a failed write leaves the existing file as it was
+ * @param  string    dst
+ * @param  io.Reader r        New contents
+ * @param  time.Time modified When the new contents were last changed, also set on the written file
+ * @return error
+ */
+func (b *Builder) writeFile(dst string, r io.Reader, modified time.Time) error {
+	write, existing, err := b.prepareWrite(dst, modified)
+	if err != nil || !write {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+
+	hash := sha256.New()
+	_, err = out.ReadFrom(io.TeeReader(r, hash))
+	if close_err := out.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		// Temp files are only readable by their owner
+		os.Chmod(tmp, 0644)
+		// So keep-newer works on the next build too
+		if !modified.IsZero() {
+			os.Chtimes(tmp, modified, modified)
+		}
+		err = b.replaceFile(tmp, dst, existing)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	b.wrote(dst, hex.EncodeToString(hash.Sum(nil)))
+
+	return nil
+}
+
+/**
+ * Rewrites a file that's merged with the one already in the output dir instead of replaced, like
+ * hekate_ipl.ini. The merge only adds to what the user has, so it's written whatever the conflict
+ * policy is, but backup still leaves a copy of the file as it was
+ * @param  string dst  Path of the existing file
+ * @param  []byte data Merged contents
+ * @return error
+ */
+func (b *Builder) writeMerged(dst string, data []byte) error {
+	action := "merged into existing file"
+
+	switch b.settings.conflicts() {
+	case conflict_backup:
+		if err := copyLocalFile(dst, dst+".bak"); err != nil {
+			return err
+		}
+		action = "backed up to " + filepath.Base(dst) + ".bak, then merged"
+	case conflict_skip, conflict_newer:
+		action = "merged into existing file, merged files are always written"
+	}
+
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		return err
+	}
+	b.conflicts = append(b.conflicts, conflict{path: dst, action: action})
+
+	return nil
+}
+
+/**
+ * Keeps track of a file the component being installed put into the output dir
+ * @param  string path
+ * @param  string sha  Empty if the file that was there was left alone
+ */
+func (b *Builder) wrote(path string, sha string) {
+	if b.written != nil {
+		b.written[path] = sha
+	}
+}
+
+/**
+ * Lists the files the component being installed put into the output dir, for the lockfile
+ * @param  string outdir
+ * @return []*InstalledFile Sorted by path
+ */
+func (b *Builder) installedFiles(outdir string) []*InstalledFile {
+	files := []*InstalledFile{}
+
+	for path, sha := range b.written {
+		rel, err := filepath.Rel(outdir, path)
+		if err != nil {
+			continue
+		}
+		files = append(files, &InstalledFile{Path: filepath.ToSlash(rel), Sha256: sha})
+	}
+
+	slices.SortFunc(files, func(a, b *InstalledFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return files
+}
+
+/**
+ * Lists every file that was already in the output dir
+ * @param  string outdir
+ */
+func (b *Builder) reportConflicts(outdir string) {
+	if len(b.conflicts) == 0 {
+		return
+	}
+
+	b.info("", "-------\nFiles already in the output directory (%s):", conflictLabel(b.settings.conflicts()))
+	for _, c := range b.conflicts {
+		name := c.path
+		if rel, err := filepath.Rel(outdir, c.path); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+		b.info("", "  %s: %s", name, c.action)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * Reader that fails after some of the contents, like a dropped download or a bad zip entry
+ */
+type failing_reader struct {
+	r io.Reader
+}
+
+func (f *failing_reader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		err = errors.New("read failed")
+	}
+	return n, err
+}
+
+func newBackupBuilder() *Builder {
+	return NewBuilder(EventSinkFunc(func(Event) {}), &Settings{Conflicts: conflict_backup})
+}
+
+/**
+ * @return []string Names of the files in a folder
+ */
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func readString(t *testing.T, file_path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(file_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteFileBackup(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "DBI.nro")
+	os.WriteFile(dst, []byte("old"), 0644)
+
+	b := newBackupBuilder()
+	if err := b.writeFile(dst, strings.NewReader("new"), time.Now()); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	if got := readString(t, dst); got != "new" {
+		t.Errorf("DBI.nro = %q, want new", got)
+	}
+	if got := readString(t, dst+".bak"); got != "old" {
+		t.Errorf("DBI.nro.bak = %q, want old", got)
+	}
+	if names := dirNames(t, dir); len(names) != 2 {
+		t.Errorf("files left: %v", names)
+	}
+	if len(b.conflicts) != 1 || b.conflicts[0].action != "backed up to DBI.nro.bak" {
+		t.Errorf("conflicts = %+v", b.conflicts)
+	}
+}
+
+func TestWriteFileBackupFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "DBI.nro")
+	os.WriteFile(dst, []byte("old"), 0644)
+
+	b := newBackupBuilder()
+	if err := b.writeFile(dst, &failing_reader{strings.NewReader("new")}, time.Now()); err == nil {
+		t.Fatal("writeFile didn't fail")
+	}
+
+	// The existing file is left as it was, without a backup nor a temp file
+	if got := readString(t, dst); got != "old" {
+		t.Errorf("DBI.nro = %q, want old", got)
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Errorf("files left: %v", names)
+	}
+	if len(b.conflicts) != 0 {
+		t.Errorf("conflicts = %+v, want none", b.conflicts)
+	}
+}
+
+func TestReplaceFileRestoresBackup(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "DBI.nro")
+	os.WriteFile(dst, []byte("old"), 0644)
+
+	b := newBackupBuilder()
+	write, existing, err := b.prepareWrite(dst, time.Now())
+	if err != nil || !write {
+		t.Fatalf("prepareWrite = %v, %v", write, err)
+	}
+
+	// The new file can't be put in place
+	if err := b.replaceFile(filepath.Join(dir, "missing"), dst, existing); err == nil {
+		t.Fatal("replaceFile didn't fail")
+	}
+
+	if got := readString(t, dst); got != "old" {
+		t.Errorf("DBI.nro = %q, want old", got)
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Errorf("files left: %v", names)
+	}
+	if len(b.conflicts) != 0 {
+		t.Errorf("conflicts = %+v, want none", b.conflicts)
+	}
+}
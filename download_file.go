@@ -1,42 +1,328 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 /**
- * Downloads a file into the current directory
- * @param  string filename Save as this
- * @param  string url
- * @return error
+ * First wait before retrying a download, doubled on each retry
+ */
+const retry_wait time.Duration = time.Second
+
+const retry_max_wait time.Duration = 30 * time.Second
+
+/**
+ * How often download progress is reported at most
+ */
+const progress_interval time.Duration = 250 * time.Millisecond
+
+/**
+ * Download errors that are worth retrying
+ */
+type transient_error struct {
+	err error
+}
+
+func (e transient_error) Error() string {
+	return e.err.Error()
+}
+
+func (e transient_error) Unwrap() error {
+	return e.err
+}
+
+/**
+ * Tells if a network error is likely to go away by itself
+ * @param  error err
+ * @return bool
+ */
+func isTransient(err error) bool {
+	var net_err net.Error
+	if errors.As(err, &net_err) && net_err.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+/**
+ * Writer that counts the bytes going through it and reports them every now and then
+ */
+type progress_writer struct {
+	received int64
+	total    int64
+	last     time.Time
+	report   func(received int64, total int64)
+}
+
+func (w *progress_writer) Write(p []byte) (int, error) {
+	w.received += int64(len(p))
+
+	if now := time.Now(); now.Sub(w.last) >= progress_interval || w.received == w.total {
+		w.last = now
+		w.report(w.received, w.total)
+	}
+
+	return len(p), nil
+}
+
+/**
+ * Gets the full size of a file from a header like "bytes 100-999/1000"
+ * @param  string content_range
+ * @return int64  0 if it's unknown
+ */
+func contentRangeTotal(content_range string) int64 {
+	_, total, _ := strings.Cut(content_range, "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+/**
+ * File kept next to a ".part" file with the URL it comes from and its validator, so it's only
+ * resumed from the very same file. Names like DBI.nro are the same in every release
+ * @param  string part
+ * @return string
  */
-func downloadFile(filename string, url string) error {
+func partInfoPath(part string) string {
+	return part + ".info"
+}
+
+/**
+ * Gets what tells that a file on the server hasn't changed, as sent in If-Range
+ * @param  http.Header header
+ * @return string      Strong ETag or Last-Modified, empty if there's neither
+ */
+func rangeValidator(header http.Header) string {
+	// Weak ETags can't be used for ranges
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+/**
+ * @param  string part
+ * @return string URL the part file comes from
+ * @return string Its validator, empty if unknown
+ */
+func readPartInfo(part string) (string, string) {
+	data, err := os.ReadFile(partInfoPath(part))
+	if err != nil {
+		return "", ""
+	}
+	url, validator, _ := strings.Cut(string(data), "\n")
+	return url, strings.TrimSpace(validator)
+}
+
+/**
+ * Removes a part file along with its info
+ * @param string part
+ */
+func removePart(part string) {
+	os.Remove(part)
+	os.Remove(partInfoPath(part))
+}
+
+/**
+ * Downloads a file, or what's missing of it, into a ".part" file next to filename
+ * @param  context.Context ctx
+ * @param  string          part   Partial file name
+ * @param  string          url
+ * @param  func(...)       report Called as the file comes in, total is 0 if unknown
+ * @return error           Wrapped in transient_error if it's worth retrying
+ */
+func downloadPart(ctx context.Context, part string, url string, report func(received int64, total int64)) error {
+	// Resume what's already there, as long as it's known to be the same file
+	var offset int64
+	validator := ""
+	if info, err := os.Stat(part); err == nil {
+		if part_url, part_validator := readPartInfo(part); part_url == url && part_validator != "" {
+			offset, validator = info.Size(), part_validator
+		} else {
+			removePart(part)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// The whole file comes back if it changed since
+		req.Header.Set("If-Range", validator)
+	}
+
 	// Get the data
-	res, err := http.Get(url)
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
+		if isTransient(err) {
+			return transient_error{err}
+		}
 		return err
 	}
 	defer res.Body.Close()
 
 	// Check server response
-	if res.StatusCode != http.StatusOK {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	progress := &progress_writer{total: max(res.ContentLength, 0), report: report}
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent && offset > 0 &&
+		strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		progress.received = offset
+		progress.total = contentRangeTotal(res.Header.Get("Content-Range"))
+	case res.StatusCode == http.StatusOK:
+		// Server doesn't do ranges or the file changed, start over
+		if err = os.WriteFile(partInfoPath(part), []byte(url+"\n"+rangeValidator(res.Header)+"\n"), 0644); err != nil {
+			return err
+		}
+	case res.StatusCode == http.StatusPartialContent || res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		removePart(part)
+		return transient_error{errors.New("could not resume, starting over")}
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+		return transient_error{fmt.Errorf("bad status: %s", res.Status)}
+	default:
 		return fmt.Errorf("bad status: %s", res.Status)
 	}
 
-	// Create the file
-	out, err := os.Create(filename)
+	// Create or continue the file
+	out, err := os.OpenFile(part, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
+	// Resumed downloads don't start from zero
+	report(progress.received, progress.total)
+
 	// Writer the body to file
-	_, err = out.ReadFrom(res.Body)
+	if _, err = io.Copy(io.MultiWriter(out, progress), res.Body); err != nil {
+		// Whatever was received is kept for resuming
+		return transient_error{err}
+	}
+
+	return nil
+}
+
+/**
+ * Downloads a file, the file only gets its name once it's complete. Transient errors are
+ * retried with exponential backoff, resuming from where it was left. Nothing is left behind if
+ * it gets canceled
+ * @param  context.Context ctx
+ * @param  string          component Component name for the log
+ * @param  string          filename  Save as this
+ * @param  string          url
+ * @return error
+ */
+func (b *Builder) downloadFile(ctx context.Context, component string, filename string, url string) error {
+	part := filename + ".part"
+	retries := b.settings.retries()
+
+	report := func(received int64, total int64) {
+		b.emit(Event{Kind: EventProgress, Component: component, File: filepath.Base(filename), Received: received, Total: total})
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := downloadPart(ctx, part, url, report)
+		if err == nil {
+			os.Remove(partInfoPath(part))
+			return os.Rename(part, filename)
+		}
+		if ctx.Err() != nil {
+			removePart(part)
+			return ctx.Err()
+		}
+
+		var transient transient_error
+		if !errors.As(err, &transient) {
+			removePart(part)
+			return err
+		}
+		if attempt >= retries {
+			return err
+		}
+
+		wait := retry_wait << attempt
+		if wait > retry_max_wait {
+			wait = retry_max_wait
+		}
+		b.info(component, "%s: %s, retrying in %s (%d/%d)", filepath.Base(filename), err, wait, attempt+1, retries)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			removePart(part)
+			return ctx.Err()
+		}
+	}
+}
+
+/**
+ * Downloads an asset into the workdir unless it's already there
+ * @param  context.Context ctx
+ * @param  string          component Component name for the log
+ * @param  *Asset          asset
+ * @return error
+ */
+func (b *Builder) getAsset(ctx context.Context, component string, asset *Asset) error {
+	if _, err := os.Stat(asset.path()); err == nil {
+		// Cached file can only be trusted if it's the expected one
+		if asset.expected == "" {
+			b.info(component, "%s already exists", asset.File)
+			return nil
+		}
+		if _, sha, err := hashFile(asset.path()); err == nil && sha == asset.expected {
+			b.info(component, "%s already exists, SHA-256 matches %s", asset.File, asset.expected_from)
+			return nil
+		}
+		b.info(component, "%s already exists but its SHA-256 doesn't match %s, downloading again", asset.File, asset.expected_from)
+		os.Remove(asset.path())
+	}
+
+	b.emit(Event{Kind: EventDownload, Component: component, File: asset.File})
+	if err := b.downloadFile(ctx, component, asset.path(), asset.Url); err != nil {
+		// Cancelling is reported once for the whole build
+		if ctx.Err() == nil {
+			b.warn(component, "Could not download %s: %s", asset.File, err)
+		}
+		b.emit(Event{Kind: EventDownload, Component: component, File: asset.File, Done: true, Err: err})
+		return err
+	}
+	b.emit(Event{Kind: EventDownload, Component: component, File: asset.File, Done: true})
+
+	if asset.expected == "" {
+		return nil
+	}
+
+	_, sha, err := hashFile(asset.path())
+	if err == nil && sha != asset.expected {
+		err = fmt.Errorf("SHA-256 is %s but %s says %s", sha, asset.expected_from, asset.expected)
+	}
 	if err != nil {
+		os.Remove(asset.path())
+		b.warn(component, "Rejected %s: %s", asset.File, err)
 		return err
 	}
+	b.info(component, "%s SHA-256 matches %s", asset.File, asset.expected_from)
 
 	return nil
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/**
+ * What happened during a build
+ */
+type EventKind int
+
+const (
+	// A component release was found, Tag is set
+	EventResolved EventKind = iota
+	// An asset download started (Done unset) or finished (Done set), Err is set if it failed
+	EventDownload
+	// Bytes of an asset download so far, Total is 0 if the server didn't tell
+	EventProgress
+	// An extract/copy/move step started (Done unset) or finished (Done set)
+	EventStep
+	// Just something worth showing
+	EventInfo
+	// Something failed but the build goes on
+	EventWarning
+	// A required step failed, Err is set and the build is aborted
+	EventFatal
+	// Build is over, Err is set if it was aborted
+	EventFinished
+	// Overall build progress, Received out of Total steps are done
+	EventBuildProgress
+	// How each selected component ended up, sent right before EventFinished
+	EventSummary
+)
+
+/**
+ * Event emitted by a Builder
+ */
+type Event struct {
+	Kind      EventKind
+	Component string
+	Message   string
+	Tag       string
+	File      string
+	Received  int64
+	Total     int64
+	Done      bool
+	Err       error
+	Results   []*ComponentResult
+}
+
+/**
+ * Anything that wants to know how a build is going, Emit may be called from several
+ * goroutines at once while downloading
+ */
+type EventSink interface {
+	Emit(Event)
+}
+
+/**
+ * Allows using a plain function as an EventSink
+ */
+type EventSinkFunc func(Event)
+
+func (f EventSinkFunc) Emit(e Event) {
+	f(e)
+}
+
+/**
+ * Sink that turns events into log text, used by both the GUI and the headless mode
+ */
+type textSink struct {
+	mutex sync.Mutex
+	write func(string)
+	// Set when the last written text didn't end the line, e.g. "Extracting… "
+	line_open bool
+}
+
+/**
+ * @param  func(string) write Where the text goes
+ * @return *textSink
+ */
+func newTextSink(write func(string)) *textSink {
+	return &textSink{write: write}
+}
+
+func (s *textSink) Emit(e Event) {
+	var txt string
+
+	// Downloads run in parallel, so their lines must say what they are about
+	prefix := ""
+	if e.Component != "" {
+		prefix = "[" + e.Component + "] "
+	}
+
+	switch e.Kind {
+	case EventResolved:
+		txt = fmt.Sprintf("* %s release: %s (%s)\n", e.Component, e.Tag, e.Message)
+	case EventDownload:
+		if e.Err != nil {
+			// Already told by a warning
+			return
+		}
+		if e.Done {
+			txt = fmt.Sprintf("  %sDownloaded %s\n", prefix, e.File)
+		} else {
+			txt = fmt.Sprintf("  %sDownloading %s…\n", prefix, e.File)
+		}
+	case EventStep:
+		if e.Done {
+			txt = "Done\n"
+		} else {
+			txt = e.Message + "… "
+		}
+	case EventInfo:
+		if prefix != "" {
+			prefix = "  " + prefix
+		}
+		txt = prefix + e.Message + "\n"
+	case EventWarning:
+		txt = "! " + prefix + e.Message + "\n"
+	case EventFatal:
+		txt = fmt.Sprintf("! Build failed: %s\n", e.Err)
+	case EventSummary:
+		txt = "-------\nSummary:\n" + formatResults(e.Results)
+	case EventProgress, EventBuildProgress, EventFinished:
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Anything but the end of a step goes on its own line
+	if s.line_open && !e.Done {
+		txt = "\n" + txt
+	}
+	s.line_open = !strings.HasSuffix(txt, "\n")
+
+	s.write(txt)
+}
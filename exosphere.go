@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * exosphere.ini keys that can be set, in the order they're shown
+ */
+var exosphere_keys = []string{
+	"blank_prodinfo_sysmmc",
+	"blank_prodinfo_emummc",
+	"allow_writing_to_cal_sysmmc",
+	"log_port",
+	"log_baud_rate",
+	"log_inverted",
+}
+
+/**
+ * UART ports Exosphère can log to, A to D
+ */
+const exosphere_max_log_port int = 3
+
+/**
+ * exosphere.ini options set on top of the ban prevention profile, or of the file on the card
+ * being updated. Nil ones are left as they are
+ */
+type ExosphereConfig struct {
+	BlankProdinfoSysmmc     *bool `json:"blank_prodinfo_sysmmc,omitempty"`
+	BlankProdinfoEmummc     *bool `json:"blank_prodinfo_emummc,omitempty"`
+	AllowWritingToCalSysmmc *bool `json:"allow_writing_to_cal_sysmmc,omitempty"`
+	// UART port, 0 to exosphere_max_log_port
+	LogPort *int `json:"log_port,omitempty"`
+	// 0 means 115200
+	LogBaudRate *int  `json:"log_baud_rate,omitempty"`
+	LogInverted *bool `json:"log_inverted,omitempty"`
+}
+
+/**
+ * @param  string key One of exosphere_keys
+ * @return **bool Nil for the numeric ones
+ * @return **int  Nil for the flags
+ */
+func (c *ExosphereConfig) field(key string) (**bool, **int) {
+	switch key {
+	case "blank_prodinfo_sysmmc":
+		return &c.BlankProdinfoSysmmc, nil
+	case "blank_prodinfo_emummc":
+		return &c.BlankProdinfoEmummc, nil
+	case "allow_writing_to_cal_sysmmc":
+		return &c.AllowWritingToCalSysmmc, nil
+	case "log_port":
+		return nil, &c.LogPort
+	case "log_baud_rate":
+		return nil, &c.LogBaudRate
+	case "log_inverted":
+		return &c.LogInverted, nil
+	}
+	return nil, nil
+}
+
+/**
+ * Sets an option as written in the ini, an empty value unsets it
+ * @param  string key
+ * @param  string value
+ * @return error
+ */
+func (c *ExosphereConfig) set(key string, value string) error {
+	flag, number := c.field(key)
+	value = strings.TrimSpace(value)
+
+	switch {
+	case flag != nil:
+		if value == "" {
+			*flag = nil
+			return nil
+		}
+		if value != "0" && value != "1" {
+			return fmt.Errorf("%s must be 0 or 1", key)
+		}
+		on := value == "1"
+		*flag = &on
+	case number != nil:
+		if value == "" {
+			*number = nil
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", key)
+		}
+		previous := *number
+		*number = &n
+		if err = c.check(); err != nil {
+			*number = previous
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown exosphere.ini option %s, must be one of %s", key, strings.Join(exosphere_keys, ", "))
+	}
+
+	return nil
+}
+
+/**
+ * @param  string key
+ * @return string As written in the ini, empty if it's not set
+ */
+func (c *ExosphereConfig) get(key string) string {
+	flag, number := c.field(key)
+
+	switch {
+	case flag != nil && *flag != nil:
+		if **flag {
+			return "1"
+		}
+		return "0"
+	case number != nil && *number != nil:
+		return strconv.Itoa(**number)
+	}
+	return ""
+}
+
+/**
+ * @return bool Nothing is set
+ */
+func (c *ExosphereConfig) empty() bool {
+	for _, key := range exosphere_keys {
+		if c.get(key) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * @return error Why Exosphère wouldn't take the values
+ */
+func (c *ExosphereConfig) check() error {
+	if c.LogPort != nil && (*c.LogPort < 0 || *c.LogPort > exosphere_max_log_port) {
+		return fmt.Errorf("log_port must be between 0 and %d", exosphere_max_log_port)
+	}
+	if c.LogBaudRate != nil && *c.LogBaudRate < 0 {
+		return errors.New("log_baud_rate can't be negative")
+	}
+	return nil
+}
+
+/**
+ * Reads the known options of an exosphere.ini
+ * @param  string text
+ * @return *ExosphereConfig Only what's in the file is set, error
+ */
+func readExosphere(text string) (*ExosphereConfig, error) {
+	c := &ExosphereConfig{}
+
+	s := parseIni([]byte(text)).section("exosphere")
+	if s == nil {
+		return c, errors.New("no [exosphere] section")
+	}
+
+	for _, key := range exosphere_keys {
+		if value, found := s.get(key); found {
+			if err := c.set(key, value); err != nil {
+				return c, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+/**
+ * Puts the options that are set into an exosphere.ini, everything else in it is kept
+ * @param  string text
+ * @return string
+ */
+func (c *ExosphereConfig) apply(text string) string {
+	ini := parseIni([]byte(text))
+	s := ini.ensureSection("exosphere")
+
+	for _, key := range exosphere_keys {
+		if value := c.get(key); value != "" {
+			s.set(key, value)
+		}
+	}
+
+	return string(ini.bytes())
+}
+
+/**
+ * Writes exosphere.ini from the ban prevention profile and the options in the settings. When
+ * updating a card, the file already on it is used instead of the profile's
+ * @param  string outdir
+ * @param  string profile Contents from the ban prevention profile
+ * @return error
+ */
+func (b *Builder) writeExosphere(outdir string, profile string) error {
+	dst := filepath.Join(outdir, "exosphere.ini")
+	options := b.settings.Exosphere
+
+	text := profile
+	existing := false
+	original := ""
+
+	// Without options to change, the card's file is left alone as any other user config
+	if b.update != nil && options != nil && !options.empty() {
+		if data, err := os.ReadFile(dst); err == nil {
+			text, existing, original = string(data), true, string(data)
+		}
+	}
+
+	if options != nil && !options.empty() {
+		if err := options.check(); err != nil {
+			return err
+		}
+		text = options.apply(text)
+	}
+	if text == "" {
+		return nil
+	}
+
+	// Values from a profile or the card are checked too
+	if _, err := readExosphere(text); err != nil {
+		return fmt.Errorf("exosphere.ini: %s", err)
+	}
+
+	if existing {
+		b.wrote(dst, "")
+		if text == original {
+			return nil
+		}
+		return b.writeMerged(dst, []byte(text))
+	}
+
+	return b.writeFile(dst, strings.NewReader(text), time.Now())
+}
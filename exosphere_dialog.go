@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+/**
+ * Choice that leaves an option as the profile or the card has it
+ */
+const exosphere_unset string = "Profile default"
+
+/**
+ * Shows a dialog to set the exosphere.ini options, saved when confirmed. Values can be read from
+ * the exosphere.ini of an SD card
+ * @param *Settings   settings
+ * @param fyne.Window w
+ */
+func showExosphereDialog(settings *Settings, w fyne.Window) {
+	flag_options := []string{exosphere_unset, "On", "Off"}
+	port_options := []string{exosphere_unset}
+	for port := 0; port <= exosphere_max_log_port; port++ {
+		port_options = append(port_options, strconv.Itoa(port))
+	}
+
+	// Every option is a select but the baud rate
+	selects := map[string]*widget.Select{}
+	baud_rate := widget.NewEntry()
+	baud_rate.SetPlaceHolder(exosphere_unset)
+	baud_rate.Validator = func(text string) error {
+		if text == "" {
+			return nil
+		}
+		if n, err := strconv.Atoi(text); err != nil || n < 0 {
+			return errors.New("must be a number, 0 means 115200")
+		}
+		return nil
+	}
+
+	form := widget.NewForm()
+
+	for _, key := range exosphere_keys {
+		switch key {
+		case "log_baud_rate":
+			form.Append(key, baud_rate)
+		case "log_port":
+			selects[key] = widget.NewSelect(port_options, nil)
+			form.Append(key, selects[key])
+		default:
+			selects[key] = widget.NewSelect(flag_options, nil)
+			form.Append(key, selects[key])
+		}
+	}
+
+	// Fills the widgets from a config
+	show := func(c *ExosphereConfig) {
+		for key, sel := range selects {
+			switch value := c.get(key); {
+			case value == "":
+				sel.SetSelected(exosphere_unset)
+			case key == "log_port":
+				sel.SetSelected(value)
+			case value == "1":
+				sel.SetSelected("On")
+			default:
+				sel.SetSelected("Off")
+			}
+		}
+		baud_rate.SetText(c.get("log_baud_rate"))
+	}
+
+	if settings.Exosphere != nil {
+		show(settings.Exosphere)
+	} else {
+		show(&ExosphereConfig{})
+	}
+
+	read_btn := widget.NewButton("Read from SD card…", func() {
+		dialog.ShowFolderOpen(func(list fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if list == nil {
+				return
+			}
+
+			data, err := os.ReadFile(filepath.Join(list.Path(), "exosphere.ini"))
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			c, err := readExosphere(string(data))
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			show(c)
+		}, w)
+	})
+
+	content := container.NewBorder(nil, read_btn, nil, nil, form)
+
+	dialog.ShowCustomConfirm("Exosphère", "Save", "Cancel", content, func(save bool) {
+		if !save {
+			return
+		}
+
+		c := &ExosphereConfig{}
+		for key, sel := range selects {
+			value := ""
+			switch sel.Selected {
+			case exosphere_unset:
+			case "On":
+				value = "1"
+			case "Off":
+				value = "0"
+			default:
+				value = sel.Selected
+			}
+			c.set(key, value)
+		}
+		if err := c.set("log_baud_rate", baud_rate.Text); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		settings.Exosphere = c
+		if c.empty() {
+			settings.Exosphere = nil
+		}
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+}
@@ -2,59 +2,195 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
 /**
- * Extracts a zip file
- * @param  string    filename
- * @param  string    outdir
- * @param  ...string prefix Prefix to skip
- * @return error
+ * Returned when some zip entries were not extracted because they could write somewhere else
+ * or can't be stored on the SD card
+ */
+var errUnsafeEntries = errors.New("unsafe entries were rejected")
+
+/**
+ * Characters FAT32 doesn't allow in names, besides control characters
+ */
+const fat32_invalid_chars string = `"*:<>?\|`
+
+/**
+ * Names that can't be used for files or folders on Windows, whatever the extension
+ */
+var reserved_names = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+/**
+ * Tells why a zip entry can't be extracted safely
+ * @param  *zip.File file
+ * @return string    Empty if it's fine
+ */
+func unsafeEntry(file *zip.File) string {
+	if file.Mode()&os.ModeSymlink != 0 {
+		return "symbolic links are not allowed"
+	}
+
+	if file.Name == "" {
+		return "empty name"
+	}
+
+	name := strings.TrimSuffix(file.Name, "/")
+
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "absolute path"
+	}
+
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "path goes outside the destination folder"
+		}
+		if reason := fat32NameProblem(segment); reason != "" {
+			return reason
+		}
+	}
+
+	// Anything the checks above missed, e.g. a volume name
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "path goes outside the destination folder"
+	}
+
+	return ""
+}
+
+/**
+ * Tells why a file or folder name can't be stored on a FAT32 SD card
+ * @param  string segment Single path element
+ * @return string Empty if it's fine
+ */
+func fat32NameProblem(segment string) string {
+	if segment == "" || segment == "." {
+		return "empty path element"
+	}
+	if len(segment) > 255 {
+		return "name is longer than 255 characters"
+	}
+
+	for _, r := range segment {
+		if r < 0x20 || r == 0x7F {
+			return "name has control characters"
+		}
+		if strings.ContainsRune(fat32_invalid_chars, r) {
+			return fmt.Sprintf("name has %q, not allowed on FAT32", r)
+		}
+	}
+
+	if strings.HasSuffix(segment, ".") || strings.HasSuffix(segment, " ") {
+		return "name ends with a dot or a space"
+	}
+
+	base, _, _ := strings.Cut(segment, ".")
+	for _, reserved := range reserved_names {
+		if strings.EqualFold(base, reserved) {
+			return fmt.Sprintf("%s is a reserved name", base)
+		}
+	}
+
+	return ""
+}
+
+/**
+ * Reader that stops as soon as its context is done
  */
-func extractZip(filename string, outdir string, prefix ...string) error {
+type ctx_reader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctx_reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+/**
+ * Extracts a zip file, stops right away if ctx is canceled. Unsafe entries are reported and
+ * skipped, the rest is extracted anyway
+ * @param  context.Context ctx
+ * @param  string          filename
+ * @param  string          outdir
+ * @param  ...string       prefix Prefix to skip
+ * @return error           Wraps errUnsafeEntries if some entry was rejected
+ */
+func (b *Builder) extractZip(ctx context.Context, filename string, outdir string, prefix ...string) error {
 	archive, err := zip.OpenReader(filename)
 	if err != nil {
 		return err
 	}
 	defer archive.Close()
 
-	check_prefix := len(prefix) > 0
+	check_prefix := len(prefix) > 0 && prefix[0] != ""
+	rejected := 0
 
 	for _, file := range archive.File {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
 		if check_prefix && strings.HasPrefix(file.Name, prefix[0]) {
 			continue
 		}
 
-		extract_path := filepath.Join(outdir, file.Name)
+		if reason := unsafeEntry(file); reason != "" {
+			b.warn("", "Rejected %s from %s: %s", file.Name, filepath.Base(filename), reason)
+			rejected++
+			continue
+		}
+
+		extract_path := filepath.Join(outdir, filepath.FromSlash(file.Name))
 
 		if file.FileInfo().IsDir() {
 			os.MkdirAll(extract_path, os.ModePerm)
 			continue
 		}
 
-		src_file, err := file.Open()
-		if err != nil {
-			log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", file.Name, err))
-			continue
-		}
-		defer src_file.Close()
+		// Not every zip has entries for its folders
+		os.MkdirAll(filepath.Dir(extract_path), os.ModePerm)
 
-		dst_file, err := os.Create(extract_path)
-		if err != nil {
-			log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", file.Name, err))
-			continue
+		if err = b.extractFile(ctx, file, extract_path); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			b.warn("", "Could not extract %s: %s", file.Name, err)
 		}
-		defer dst_file.Close()
+	}
 
-		_, err = dst_file.ReadFrom(src_file)
-		if err != nil {
-			log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", file.Name, err))
-		}
+	if rejected > 0 {
+		return fmt.Errorf("%d %w", rejected, errUnsafeEntries)
 	}
 
 	return nil
 }
+
+/**
+ * Extracts a single file from a zip following the conflict policy
+ * @param  context.Context ctx
+ * @param  *zip.File       file
+ * @param  string          extract_path
+ * @return error
+ */
+func (b *Builder) extractFile(ctx context.Context, file *zip.File, extract_path string) error {
+	src_file, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src_file.Close()
+
+	return b.writeFile(extract_path, ctx_reader{ctx, src_file}, file.Modified)
+}
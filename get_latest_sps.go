@@ -1,15 +1,17 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"compress/flate"
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
+	"path"
 	"strings"
+
+	"golang.org/x/net/html"
 )
 
 var compressed_forum_url = []byte{
@@ -25,86 +27,248 @@ var compressed_forum_url = []byte{
 	0x7B, 0xAC, 0x7E, 0x05, 0x00, 0x00, 0xFF, 0xFF,
 }
 
+/**
+ * What was found in a forum page
+ */
 type forumdata struct {
+	// "Download Here" link to the post with the SPs, empty if the SPs are in the page
 	redirect_url string
 	download_url string
 	sps_filename string
 }
 
-func getForumData(forum_url string) (*forumdata, error) {
-	// Load forum post
-	res, err := http.Get(forum_url)
+/**
+ * File attached to a forum post
+ */
+type forum_attachment struct {
+	url  string
+	name string
+}
+
+/**
+ * @param  string name File name or attachment URL
+ * @return bool   It's the SPs for Hekate and Atmosphère
+ */
+func isSPsAttachment(name string) bool {
+	name = strings.ToLower(strings.ReplaceAll(name, "+", "-"))
+	return strings.Contains(name, "hekate-ams") || strings.Contains(name, "hekate-atmosphere")
+}
+
+/**
+ * Guesses an attachment's file name from its URL, e.g. /attachments/some-file-zip.12345/
+ * @param  string attachment_url
+ * @return string Empty if it can't be told
+ */
+func attachmentName(attachment_url string) string {
+	u, err := url.Parse(attachment_url)
 	if err != nil {
-		return nil, err
+		return ""
 	}
-	defer res.Body.Close()
 
-	// Check server response
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status: %s", res.Status)
+	slug := path.Base(strings.TrimSuffix(u.Path, "/"))
+	// Drop the attachment id
+	if i := strings.LastIndex(slug, "."); i > 0 {
+		slug = slug[:i]
+	}
+	if i := strings.LastIndex(slug, "-"); i > 0 && slug[i+1:] == "zip" {
+		return slug[:i] + ".zip"
 	}
 
-	scanner := bufio.NewScanner(res.Body)
+	return ""
+}
+
+/**
+ * Finds the SPs attachment, or the link to the post that has it, in a forum page
+ * @param  io.Reader r    Page HTML
+ * @param  *url.URL  base Page URL, to resolve relative links
+ * @return *forumdata, error
+ */
+func parseForumPage(r io.Reader, base *url.URL) (*forumdata, error) {
+	z := html.NewTokenizer(r)
 
 	var fd forumdata
+	var attachments []*forum_attachment
 
-	for scanner.Scan() {
-		current_line := scanner.Text()
+	// Link being read and its text
+	var link_href string
+	var link_text strings.Builder
+	in_link := false
+	in_attachment := false
 
-		// Switches for avoiding re-search
-		download_here_not_found := true
-		download_url_not_found := true
+	// Text of a file name element, e.g. <span class="file-name">
+	in_file_name := false
+	var file_name strings.Builder
+
+	resolve := func(href string) string {
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return ""
+		}
+		return u.String()
+	}
 
-		// Search for "Download Here" hyperlink
-		if download_here_not_found {
-			text_end := strings.Index(current_line, "\" class=\"link link--internal\">Download Here</a>")
-			if text_end > 0 {
-				text_start := strings.Index(current_line, forum_url)
-				fd.redirect_url = current_line[text_start:text_end]
-				download_here_not_found = false
+	// Names go to the attachment link they follow, they're used as local file names
+	name := func(text string) {
+		text = strings.TrimSpace(text)
+		if len(attachments) == 0 || !strings.HasSuffix(strings.ToLower(text), ".zip") || strings.ContainsAny(text, `/\:`) {
+			return
+		}
+		if last := attachments[len(attachments)-1]; last.name == "" {
+			last.name = text
+		}
+	}
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, fmt.Errorf("could not read the forum page: %w", err)
+			}
+			return pickForumData(&fd, attachments, base)
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, has_attr := z.TagName()
+			attrs := map[string]string{}
+			for has_attr {
+				var key, value []byte
+				key, value, has_attr = z.TagAttr()
+				attrs[string(key)] = string(value)
+			}
+
+			if strings.Contains(" "+attrs["class"]+" ", " file-name ") {
+				in_file_name = true
+				file_name.Reset()
+				name(attrs["title"])
+			}
+
+			// Thumbnails inside attachment links, like <img title="file.zip">
+			if in_attachment {
+				name(attrs["title"])
+			}
+
+			if string(tag) != "a" {
 				continue
 			}
-		}
 
-		// Search for SPs download URL
-		if download_url_not_found {
-			text_start := strings.Index(current_line, "/attachments/hekate-ams")
-			if text_start > 0 {
-				text_end := strings.Index(current_line, "\" target")
-				fd.download_url = forum_url[:19] + current_line[text_start:text_end]
-				download_url_not_found = false
+			in_link = true
+			link_href = attrs["href"]
+			link_text.Reset()
+
+			href := resolve(link_href)
+			in_attachment = strings.Contains(href, "/attachments/")
+			if in_attachment {
+				attachments = append(attachments, &forum_attachment{url: href})
+				name(attrs["title"])
+			}
+
+		case html.TextToken:
+			if in_link {
+				link_text.Write(z.Text())
+			}
+			if in_file_name {
+				file_name.Write(z.Text())
+			}
+
+		case html.EndTagToken:
+			tag, _ := z.TagName()
+
+			if in_file_name && string(tag) != "a" {
+				in_file_name = false
+				name(file_name.String())
+			}
+
+			if string(tag) != "a" || !in_link {
 				continue
 			}
+			text := strings.TrimSpace(link_text.String())
+			if strings.EqualFold(text, "Download Here") && fd.redirect_url == "" {
+				fd.redirect_url = resolve(link_href)
+			}
+			if in_attachment {
+				name(text)
+			}
+
+			in_link = false
+			in_attachment = false
+		}
+	}
+}
+
+/**
+ * Chooses the SPs among the attachments found in a page
+ * @param  *forumdata          fd
+ * @param  []*forum_attachment attachments
+ * @param  *url.URL            base Page URL, for the errors
+ * @return *forumdata, error
+ */
+func pickForumData(fd *forumdata, attachments []*forum_attachment, base *url.URL) (*forumdata, error) {
+	for _, a := range attachments {
+		if !isSPsAttachment(a.name) && !isSPsAttachment(a.url) {
+			continue
 		}
 
-		// Finally search for SPs zip filename
-		text_start := strings.Index(current_line, "Hekate+AMS")
-		if text_start > 0 {
-			text_end := strings.Index(current_line, "\">")
-			fd.sps_filename = current_line[text_start:text_end]
-			break
+		fd.download_url = a.url
+		fd.sps_filename = a.name
+		if fd.sps_filename == "" {
+			fd.sps_filename = attachmentName(a.url)
 		}
+		if fd.sps_filename == "" {
+			return nil, fmt.Errorf("found the SPs at %s but not their file name", a.url)
+		}
+
+		return fd, nil
+	}
+
+	if fd.redirect_url != "" {
+		return fd, nil
 	}
 
-	if err = scanner.Err(); err != nil {
+	if len(attachments) > 0 {
+		return nil, fmt.Errorf("none of the %d attachments in %s look like SPs for Hekate and Atmosphère", len(attachments), base)
+	}
+	return nil, fmt.Errorf("no SPs attachment or \"Download Here\" link found in %s (has the forum layout changed?)", base)
+}
+
+/**
+ * Loads a forum page and looks for the SPs in it
+ * @param  context.Context ctx
+ * @param  string          forum_url
+ * @return *forumdata, error
+ */
+func getForumData(ctx context.Context, forum_url string) (*forumdata, error) {
+	// Load forum post
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, forum_url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
-	// Error if nothing was found
-	if fd.redirect_url == "" && fd.sps_filename == "" && fd.download_url == "" {
-		return nil, errors.New("no SPs info found (bad forum URL?)")
+	// Check server response
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status from %s: %s", forum_url, res.Status)
 	}
 
-	return &fd, nil
+	// Links are relative to where the redirects ended up
+	return parseForumPage(res.Body, res.Request.URL)
 }
 
-func getLatestSPs() (*string, error) {
-	var b bytes.Buffer
+/**
+ * Finds the latest SPs in the forum, following the "Download Here" link if needed
+ * @param  context.Context ctx
+ * @return *forumdata, error
+ */
+func findLatestSPs(ctx context.Context) (*forumdata, error) {
+	var forum_url bytes.Buffer
 	r := flate.NewReader(bytes.NewReader(compressed_forum_url))
-	b.ReadFrom(r)
+	forum_url.ReadFrom(r)
 	r.Close()
 
-	fd, err := getForumData(b.String())
+	fd, err := getForumData(ctx, forum_url.String())
 
 	if err != nil {
 		return nil, err
@@ -112,26 +276,16 @@ func getLatestSPs() (*string, error) {
 
 	// Check if SPs zip info was not found
 	if fd.sps_filename == "" {
-		fd, err = getForumData(fd.redirect_url)
+		redirect_url := fd.redirect_url
+		fd, err = getForumData(ctx, redirect_url)
 
 		if err != nil {
 			return nil, err
 		}
-	}
-
-	sps_file_path := filepath.Join(workdir, fd.sps_filename)
-
-	// Download if not exists
-	if _, err := os.Stat(sps_file_path); err == nil {
-		log_add(fmt.Sprintf("* %s already exists\n", fd.sps_filename))
-	} else {
-		log_add(fmt.Sprintf("* Downloading %s… ", fd.sps_filename))
-		if err = downloadFile(sps_file_path, fd.download_url); err != nil {
-			return nil, err
-		} else {
-			log_add("Done\n")
+		if fd.sps_filename == "" {
+			return nil, fmt.Errorf("no SPs attachment found in %s, linked as \"Download Here\"", redirect_url)
 		}
 	}
 
-	return &sps_file_path, nil
+	return fd, nil
 }
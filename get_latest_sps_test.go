@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const test_thread_url = "https://gbatemp.net/threads/sigpatches-for-atmosphere-hekate-fss0-fusee-package3.571543/"
+
+func parseForumFixture(t *testing.T, name string, page_url string) (*forumdata, error) {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	base, err := url.Parse(page_url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parseForumPage(f, base)
+}
+
+func TestParseForumPage(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		page_url string
+		want     forumdata
+	}{
+		{
+			fixture:  "forum_current.html",
+			page_url: test_thread_url + "page-5",
+			want: forumdata{
+				download_url: "https://gbatemp.net/attachments/hekate-ams-package3-sigpatches-1-7-1-cfw-19-0-0_v0-zip.455002/",
+				sps_filename: "Hekate+AMS-package3-sigpatches-1.7.1-cfw-19.0.0_V0.zip",
+			},
+		},
+		{
+			fixture:  "forum_previous.html",
+			page_url: test_thread_url + "page-3",
+			want: forumdata{
+				download_url: "https://gbatemp.net/attachments/hekate-ams-package3-sigpatches-1-6-2-cfw-18-0-1-zip.398427/",
+				sps_filename: "Hekate+AMS-package3-sigpatches-1.6.2-cfw-18.0.1.zip",
+			},
+		},
+		{
+			// Only the first "Download Here" link counts
+			fixture:  "forum_redirect.html",
+			page_url: test_thread_url,
+			want: forumdata{
+				redirect_url: test_thread_url + "page-5#post-10000001",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			fd, err := parseForumFixture(t, tt.fixture, tt.page_url)
+			if err != nil {
+				t.Fatalf("parseForumPage: %v", err)
+			}
+			if *fd != tt.want {
+				t.Errorf("parseForumPage = %+v, want %+v", *fd, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseForumPageErrors(t *testing.T) {
+	fd, err := parseForumFixture(t, "forum_no_sps.html", test_thread_url+"page-5")
+	want := "none of the 1 attachments in " + test_thread_url + "page-5 look like SPs for Hekate and Atmosphère"
+	if err == nil || err.Error() != want {
+		t.Errorf("parseForumPage = %+v, %v, want error %q", fd, err, want)
+	}
+
+	base, _ := url.Parse(test_thread_url)
+	fd, err = parseForumPage(strings.NewReader("<html><body><p>Page not found</p></body></html>"), base)
+	want = "no SPs attachment or \"Download Here\" link found in " + test_thread_url + " (has the forum layout changed?)"
+	if err == nil || err.Error() != want {
+		t.Errorf("parseForumPage = %+v, %v, want error %q", fd, err, want)
+	}
+}
+
+func TestPickForumData(t *testing.T) {
+	base, _ := url.Parse(test_thread_url)
+
+	// The file name comes from the attachment URL when the page doesn't show it
+	fd, err := pickForumData(&forumdata{}, []*forum_attachment{
+		{url: "https://gbatemp.net/attachments/boot-menu-png.455001/"},
+		{url: "https://gbatemp.net/attachments/hekate-ams-sigpatches-zip.455002/"},
+	}, base)
+	if err != nil {
+		t.Fatalf("pickForumData: %v", err)
+	}
+	if fd.sps_filename != "hekate-ams-sigpatches.zip" || fd.download_url != "https://gbatemp.net/attachments/hekate-ams-sigpatches-zip.455002/" {
+		t.Errorf("pickForumData = %+v", *fd)
+	}
+
+	// An attachment wins over a "Download Here" link
+	fd, err = pickForumData(&forumdata{redirect_url: test_thread_url + "page-5"}, []*forum_attachment{
+		{url: "https://gbatemp.net/attachments/455002/", name: "Hekate+AMS-sigpatches.zip"},
+	}, base)
+	if err != nil || fd.sps_filename != "Hekate+AMS-sigpatches.zip" {
+		t.Errorf("pickForumData = %+v, %v", fd, err)
+	}
+
+	_, err = pickForumData(&forumdata{}, []*forum_attachment{
+		{url: "https://gbatemp.net/attachments/hekate-ams-sigpatches.455002/"},
+	}, base)
+	want := "found the SPs at https://gbatemp.net/attachments/hekate-ams-sigpatches.455002/ but not their file name"
+	if err == nil || err.Error() != want {
+		t.Errorf("pickForumData error = %v, want %q", err, want)
+	}
+}
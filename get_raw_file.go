@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+/**
+ * Tells the name a plain URL download is saved under
+ * @param  string raw_url
+ * @return string, error
+ */
+func rawFileName(raw_url string) (string, error) {
+	u, err := url.Parse(raw_url)
+	if err != nil {
+		return "", err
+	}
+
+	// Named after the path only, queries like ?token=… don't belong in a file name
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%s has no file name", raw_url)
+	}
+	if problem := fat32NameProblem(name); problem != "" {
+		return "", fmt.Errorf("%s: bad file name: %s", raw_url, problem)
+	}
+
+	return name, nil
+}
+
+/**
+ * Downloads a file from a plain URL into the workdir
+ * @param  context.Context ctx
+ * @param  string          component Component name for the log
+ * @param  string          raw_url
+ * @return *Asset, error
+ */
+func (b *Builder) getRawFile(ctx context.Context, component string, raw_url string) (*Asset, error) {
+	name, err := rawFileName(raw_url)
+	if err != nil {
+		return nil, err
+	}
+
+	asset := &Asset{File: name, Url: raw_url}
+
+	if err := b.getAsset(ctx, component, asset); err != nil {
+		return nil, err
+	}
+
+	return asset, nil
+}
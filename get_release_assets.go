@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type GitHubAsset struct {
+	BrowserDownloadUrl string `json:"browser_download_url"`
+	// Formatted as "sha256:{hex}", not every API has it
+	Digest string `json:"digest"`
+}
+
+type GitHubResponse struct {
+	TagName     string    `json:"tag_name"`
+	Prerelease  bool      `json:"prerelease"`
+	Draft       bool      `json:"draft"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []GitHubAsset
+}
+
+/**
+ * How many releases are asked for each time
+ */
+const releases_per_page int = 30
+
+/**
+ * How many pages are checked at most when looking for the latest release
+ */
+const releases_max_pages int = 5
+
+/**
+ * Where GitHub's API is, tests point it to their own server
+ */
+var github_api_url = "https://api.github.com"
+
+/**
+ * Does a GET request to a GitHub or Gitea releases API and decodes the JSON response
+ * @param  context.Context ctx
+ * @param  string          endpoint Path after the repo, e.g. "/releases"
+ * @param  string          repo     Must be formatted as {author}/{repo}
+ * @param  any             out      Where the response is decoded into
+ * @param  ...string       api_url  Custom API URL if it's not for GitHub, https:// unless it says otherwise
+ * @return error
+ */
+func releasesApiGet(ctx context.Context, endpoint string, repo string, out any, api_url ...string) error {
+	base_url := github_api_url
+	no_gh := len(api_url) > 0 && api_url[0] != ""
+
+	if no_gh {
+		base_url = api_url[0]
+		if !strings.Contains(base_url, "://") {
+			base_url = "https://" + base_url
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base_url+"/repos/"+repo+endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header = http.Header{
+		"Accept":               {"application/vnd.github+json"},
+		"X-GitHub-Api-Version": {"2022-11-28"},
+	}
+
+	if no_gh {
+		req.Header = http.Header{
+			"Accept": {"application/json"},
+		}
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Check server response
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", res.Status)
+	}
+
+	return jsoniter.NewDecoder(res.Body).Decode(out)
+}
+
+/**
+ * Lists a page of releases of a repo, newest first
+ * @param  context.Context ctx
+ * @param  string          repo    Must be formatted as {author}/{repo}
+ * @param  int             page    Starting from 1
+ * @param  ...string       api_url Custom API URL if it's not for GitHub
+ * @return []GitHubResponse, error
+ */
+func listReleases(ctx context.Context, repo string, page int, api_url ...string) ([]GitHubResponse, error) {
+	var releases []GitHubResponse
+
+	// Gitea uses limit instead of per_page
+	err := releasesApiGet(
+		ctx,
+		fmt.Sprintf("/releases?per_page=%d&limit=%d&page=%d", releases_per_page, releases_per_page, page),
+		repo, &releases, api_url...,
+	)
+
+	return releases, err
+}
+
+/**
+ * Tells if a release is newer than another one according to the order setting
+ * @param  *GitHubResponse a
+ * @param  *GitHubResponse b
+ * @param  string          order By semantic version or publish date
+ * @return bool
+ */
+func isNewerRelease(a *GitHubResponse, b *GitHubResponse, order string) bool {
+	if order == order_semver {
+		a_ver, a_ok := parseSemver(a.TagName)
+		b_ver, b_ok := parseSemver(b.TagName)
+
+		// Tags that are not versions lose, or fall back to publish date if both aren't
+		switch {
+		case a_ok && b_ok:
+			if diff := a_ver.compare(b_ver); diff != 0 {
+				return diff > 0
+			}
+		case a_ok != b_ok:
+			return a_ok
+		}
+	}
+
+	return a.PublishedAt.After(b.PublishedAt)
+}
+
+/**
+ * Finds the release to use according to the version and order settings
+ * @param  context.Context ctx
+ * @param  string          repo    Must be formatted as {author}/{repo}
+ * @param  string          version Latest stable, latest including prereleases or an exact tag
+ * @param  string          order   By semantic version or publish date, only for latest versions
+ * @param  ...string       api_url Custom API URL if it's not for GitHub
+ * @return *GitHubResponse, string Rule used for picking it, error
+ */
+func resolveRelease(ctx context.Context, repo string, version string, order string, api_url ...string) (*GitHubResponse, string, error) {
+	if version != version_latest && version != version_prerelease {
+		var release GitHubResponse
+		if err := releasesApiGet(ctx, "/releases/tags/"+url.PathEscape(version), repo, &release, api_url...); err != nil {
+			return nil, "", fmt.Errorf("release %s: %s", version, err)
+		}
+		return &release, "pinned", nil
+	}
+
+	var newest *GitHubResponse
+	drafts, prereleases := 0, 0
+
+	// Releases are listed by creation date, so by date once a page has a candidate older pages can
+	// be skipped. A higher version can be on any page, up to releases_max_pages
+	for page := 1; page <= releases_max_pages && (newest == nil || order == order_semver); page++ {
+		releases, err := listReleases(ctx, repo, page, api_url...)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i := range releases {
+			release := &releases[i]
+
+			if release.Draft {
+				drafts++
+				continue
+			}
+			if release.Prerelease && version != version_prerelease {
+				prereleases++
+				continue
+			}
+
+			if newest == nil || isNewerRelease(release, newest, order) {
+				newest = release
+			}
+		}
+
+		if len(releases) < releases_per_page {
+			break
+		}
+	}
+
+	if newest == nil {
+		return nil, "", errors.New("no releases found")
+	}
+
+	rule := fmt.Sprintf("%s by %s", strings.ToLower(versionLabel(version)), orderLabel(order))
+	if drafts > 0 {
+		rule += fmt.Sprintf(", %d draft(s) skipped", drafts)
+	}
+	if prereleases > 0 {
+		rule += fmt.Sprintf(", %d prerelease(s) skipped", prereleases)
+	}
+
+	return newest, rule, nil
+}
+
+/**
+ * Tells the name an asset is saved under from its download URL
+ * @param  string download_url
+ * @return string, error
+ */
+func assetFileName(download_url string) (string, error) {
+	u, err := url.Parse(download_url)
+	if err != nil {
+		return "", err
+	}
+
+	// Unescaped after splitting so %2F can't add folders, and a + in a path is just a +
+	name, err := url.PathUnescape(path.Base(u.EscapedPath()))
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", download_url, err)
+	}
+	if !isPlainFileName(name) {
+		return "", fmt.Errorf("%s: %q is not a plain file name", download_url, name)
+	}
+
+	return name, nil
+}
+
+/**
+ * Gets files from a GitHub's repo release according to a regex filter
+ * @param  context.Context ctx
+ * @param  string          component    Component name for the log
+ * @param  string          repo         Must be formatted as {author}/{repo}
+ * @param  string          filter_regex Regex filter for the name of the asset to be downloaded
+ * @param  string          version      Latest stable, latest including prereleases or an exact tag
+ * @param  string          order        By semantic version or publish date
+ * @param  ...string       api_url      Custom API URL if it's not for GitHub
+ * @return *GitHubResponse Release the files are from
+ * @return []*Asset, error
+ */
+func (b *Builder) getReleaseAssets(ctx context.Context, component string, repo string, filter_regex string, version string, order string, api_url ...string) (*GitHubResponse, []*Asset, error) {
+	release, rule, err := resolveRelease(ctx, repo, version, order, api_url...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.emit(Event{Kind: EventResolved, Component: component, Tag: release.TagName, Message: rule})
+
+	assets := []*Asset{}
+
+	re := regexp.MustCompile(filter_regex)
+
+	// Checksum files in the release, only fetched if some asset has no digest
+	var checksums map[string]string
+
+	for _, gh_asset := range release.Assets {
+		if re.MatchString(gh_asset.BrowserDownloadUrl) {
+			filename, err := assetFileName(gh_asset.BrowserDownloadUrl)
+			if err != nil {
+				return nil, nil, err
+			}
+			asset := &Asset{File: filename, Url: gh_asset.BrowserDownloadUrl}
+
+			if asset.expected = digestSha256(gh_asset.Digest); asset.expected != "" {
+				asset.expected_from = "release digest"
+			} else {
+				if checksums == nil {
+					checksums = b.getReleaseChecksums(ctx, component, release)
+				}
+				if asset.expected = checksums[filename]; asset.expected != "" {
+					asset.expected_from = "release checksum file"
+				}
+			}
+
+			if err = b.getAsset(ctx, component, asset); err != nil {
+				return nil, nil, err
+			}
+
+			assets = append(assets, asset)
+		}
+	}
+
+	return release, assets, nil
+}
+
+/**
+ * Gets the SHA-256 of the release files from any checksum file in it
+ * @param  context.Context ctx
+ * @param  string          component Component name for the log
+ * @param  *GitHubResponse release
+ * @return map[string]string Never nil, empty if there are no checksum files
+ */
+func (b *Builder) getReleaseChecksums(ctx context.Context, component string, release *GitHubResponse) map[string]string {
+	checksums := map[string]string{}
+
+	for _, gh_asset := range release.Assets {
+		filename, err := assetFileName(gh_asset.BrowserDownloadUrl)
+		if err != nil || !checksum_file_re.MatchString(filename) {
+			continue
+		}
+
+		file_checksums, err := getChecksums(ctx, filename, gh_asset.BrowserDownloadUrl)
+		if err != nil {
+			b.warn(component, "Could not get checksums from %s: %s", filename, err)
+			continue
+		}
+		for name, sha := range file_checksums {
+			checksums[name] = sha
+		}
+	}
+
+	return checksums
+}
@@ -5,6 +5,7 @@ go 1.22.1
 require (
 	fyne.io/fyne/v2 v2.4.4
 	github.com/json-iterator/go v1.1.12
+	golang.org/x/net v0.22.0
 )
 
 require (
@@ -32,7 +33,6 @@ require (
 	github.com/yuin/goldmark v1.7.0 // indirect
 	golang.org/x/image v0.15.0 // indirect
 	golang.org/x/mobile v0.0.0-20240326195318-268e6c3a80d1 // indirect
-	golang.org/x/net v0.22.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
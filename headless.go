@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/**
+ * Options taken from the command line
+ */
+type cli_options struct {
+	headless bool
+	dos      dos_type
+	outdir   string
+	workdir  string
+	lockfile string
+	update   bool
+	detect   bool
+	retries  int
+	// Conflict policy, empty if not given
+	conflicts string
+	// Own SPs zip or folder, nil if not given, empty to go back to downloading them
+	local_sps *string
+	// Where the SPs are looked for, nil if not given
+	sps_sources []string
+	// SPs check policy, empty if not given
+	sps_check string
+	// Ban prevention profile, empty if not given
+	ban_profile string
+	// exosphere.ini options as key and value, an empty value unsets it
+	exosphere [][2]string
+	// Boot entries for hekate_ipl.ini, nil if not given
+	boot_entries []BootEntry
+	// Autoboot entry, -1 if not given
+	autoboot int
+	versions release_flags
+	orders   release_flags
+}
+
+/**
+ * Repeatable id=value flag for components with releases
+ */
+type release_flags map[string]string
+
+func (r release_flags) String() string {
+	pairs := []string{}
+	for id, value := range r {
+		pairs = append(pairs, id+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (r release_flags) Set(flag_value string) error {
+	id, value, found := strings.Cut(flag_value, "=")
+	if !found || value == "" {
+		return errors.New("must be formatted as id=value")
+	}
+
+	c := getComponent(id)
+	if c == nil {
+		return fmt.Errorf("unknown component %s", id)
+	}
+	if c.source != sourceGitHub && c.source != sourceGitea {
+		return fmt.Errorf("%s has no releases to choose from", id)
+	}
+
+	r[id] = value
+	return nil
+}
+
+/**
+ * Repeatable -order id=order flag, only semver and date are valid
+ */
+type order_flags struct {
+	release_flags
+}
+
+func (o order_flags) Set(flag_value string) error {
+	if _, order, _ := strings.Cut(flag_value, "="); order != order_semver && order != order_date {
+		return fmt.Errorf("order must be %s or %s", order_semver, order_date)
+	}
+	return o.release_flags.Set(flag_value)
+}
+
+/**
+ * Parses the command-line arguments, defaults match the GUI check boxes
+ * @param  []string args Arguments without the program name
+ * @return *cli_options, error
+ */
+func parseArgs(args []string) (*cli_options, error) {
+	opts := cli_options{
+		versions: release_flags{},
+		orders:   release_flags{},
+	}
+
+	flags := flag.NewFlagSet("make-nsw-sd", flag.ContinueOnError)
+
+	flags.BoolVar(&opts.headless, "headless", false, "Build without showing the GUI")
+	flags.StringVar(&opts.outdir, "outdir", "", "Output directory (default SD_<hex timestamp>)")
+	flags.StringVar(&opts.workdir, "workdir", "", "Folder for downloaded files (default \"workdir\")")
+	flags.IntVar(&opts.retries, "retries", -1, "How many times a failed download is retried (default from settings, or 3)")
+	flags.BoolVar(&opts.update, "update", false, "Update the SD card at -outdir in place, installed components are detected and component flags are ignored")
+	flags.BoolVar(&opts.detect, "detect", false, "Show the versions installed in -outdir against the latest ones and exit")
+	flags.StringVar(&opts.lockfile, "lockfile", "", "Rebuild exactly what a previous build's "+lockfile_name+" says, component flags are ignored")
+	flags.Var(opts.versions, "version", "Release to use as `id=version`, version being latest, prerelease or an exact tag. Can be repeated and is saved for the next builds")
+	flags.Func("conflicts", "What to do with files already in the output directory: "+strings.Join(conflict_policies, ", ")+". Saved for the next builds", func(policy string) error {
+		if !slices.Contains(conflict_policies, policy) {
+			return fmt.Errorf("must be one of %s", strings.Join(conflict_policies, ", "))
+		}
+		opts.conflicts = policy
+		return nil
+	})
+	flags.Func("local-sps", "Zip file or folder with your own SPs, used instead of downloading them. An empty `path` goes back to downloading them. Saved for the next builds", func(local_path string) error {
+		if local_path != "" {
+			if _, err := checkLocalSPs(local_path); err != nil {
+				return err
+			}
+			// Builds may run from somewhere else next time
+			if abs, err := filepath.Abs(local_path); err == nil {
+				local_path = abs
+			}
+		}
+		opts.local_sps = &local_path
+		return nil
+	})
+	flags.Func("sps-source", "Where the SPs are looked for: forum, github:{author}/{repo} or the `URL` of a zip file. Can be repeated to try them in order and is saved for the next builds", func(source string) error {
+		if err := checkSPsSource(source); err != nil {
+			return err
+		}
+		opts.sps_sources = append(opts.sps_sources, source)
+		return nil
+	})
+	flags.Func("sps-check", "What to do when the SPs look older than Atmosphère: "+strings.Join(sps_check_policies, ", ")+". Saved for the next builds", func(policy string) error {
+		if !slices.Contains(sps_check_policies, policy) {
+			return fmt.Errorf("must be one of %s", strings.Join(sps_check_policies, ", "))
+		}
+		opts.sps_check = policy
+		return nil
+	})
+	flags.Func("ban-profile", "Ban prevention files written with Atmosphère: default, emummc-only, sysmmc-only or the `name` of one of your profiles. Saved for the next builds", func(name string) error {
+		if _, err := findBanProfile(name); err != nil {
+			return err
+		}
+		opts.ban_profile = name
+		return nil
+	})
+	flags.Func("exosphere", "exosphere.ini option as `key=value`, key being "+strings.Join(exosphere_keys, ", ")+" and value default to use the profile's. Can be repeated and is saved for the next builds", func(flag_value string) error {
+		key, value, found := strings.Cut(flag_value, "=")
+		if !found {
+			return errors.New("must be formatted as key=value")
+		}
+		if value == "default" {
+			value = ""
+		}
+		// Checked right away, a mistake would only show up in the middle of the build
+		if err := (&ExosphereConfig{}).set(key, value); err != nil {
+			return err
+		}
+		opts.exosphere = append(opts.exosphere, [2]string{key, value})
+		return nil
+	})
+	flags.Func("boot-entry", "Boot entry written into bootloader/hekate_ipl.ini as `kind[,options]`, kind being emummc, sysmmc or stock and options pkg3, fss0, kip1patch, icon=path or name=text. none removes them all. Can be repeated and is saved for the next builds", func(spec string) error {
+		if opts.boot_entries == nil {
+			opts.boot_entries = []BootEntry{}
+		}
+		if spec == "none" {
+			opts.boot_entries = opts.boot_entries[:0]
+			return nil
+		}
+		e, err := parseBootEntry(spec)
+		if err != nil {
+			return err
+		}
+		opts.boot_entries = append(opts.boot_entries, e)
+		return nil
+	})
+	flags.IntVar(&opts.autoboot, "autoboot", -1, "Boot entry started right away, counting from 1, 0 turns it off. Saved for the next builds")
+	flags.Var(order_flags{opts.orders}, "order", "How the latest release is chosen as `id=order`, order being date (publish date) or semver. Can be repeated and is saved for the next builds")
+
+	// One flag for each component check box
+	dos_flags := map[string]*bool{}
+	for _, c := range components {
+		usage := "Add " + c.label
+		if len(c.depends) > 0 {
+			usage += " (needs -" + strings.Join(c.depends, " -") + ")"
+		}
+		dos_flags[c.id] = flags.Bool(c.id, c.checked, usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	opts.dos = dos_type{}
+	for id, value := range dos_flags {
+		opts.dos[id] = *value
+	}
+
+	return &opts, nil
+}
+
+/**
+ * Runs the build pipeline without a GUI, the log goes to the standard output
+ * @param  *cli_options opts
+ * @return int          Exit code
+ */
+func runHeadless(opts *cli_options, settings *Settings) int {
+	if opts.detect {
+		return showVersions(opts.outdir, settings)
+	}
+
+	if opts.update && (opts.outdir == "" || opts.lockfile != "") {
+		fmt.Fprintln(os.Stderr, "! -update needs -outdir and can't be used with -lockfile")
+		return 2
+	}
+
+	if opts.lockfile == "" && !opts.update {
+		if err := opts.dos.check(); err != nil {
+			fmt.Fprintf(os.Stderr, "! %s\n", strings.TrimSpace(err.Error()))
+			return 2
+		}
+	}
+
+	outdir := opts.outdir
+	if outdir == "" {
+		outdir = newOutdir()
+	}
+
+	// The sink already logs why the build failed
+	builder := NewBuilder(newTextSink(func(txt string) {
+		fmt.Print(txt)
+	}), settings)
+
+	// Ctrl+C cancels the build cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch {
+	case opts.update:
+		err = builder.Update(ctx, outdir)
+	case opts.lockfile != "":
+		err = builder.RunLockfile(ctx, opts.lockfile, outdir)
+	default:
+		err = builder.Run(ctx, opts.dos, outdir)
+	}
+	if err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+/**
+ * Prints the versions installed on an SD card against the latest ones
+ * @param  string    sd_root
+ * @param  *Settings settings
+ * @return int       Exit code
+ */
+func showVersions(sd_root string, settings *Settings) int {
+	if sd_root == "" {
+		fmt.Fprintln(os.Stderr, "! -detect needs -outdir")
+		return 2
+	}
+
+	statuses := detectVersions(context.Background(), sd_root, settings)
+	if len(statuses) == 0 {
+		fmt.Fprintf(os.Stderr, "! No known components found in %s\n", sd_root)
+		return 1
+	}
+
+	for _, s := range statuses {
+		outdated := ""
+		if s.outdated {
+			outdated = " (outdated)"
+		}
+		fmt.Printf("%s: %s%s\n", s.c.name, s.note(), outdated)
+	}
+
+	return 0
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/**
+ * Hekate's boot config, relative to the SD root
+ */
+var hekate_ini_path = filepath.Join("bootloader", "hekate_ipl.ini")
+
+/**
+ * Kinds of boot entries
+ */
+const (
+	boot_emummc string = "emummc"
+	boot_sysmmc string = "sysmmc"
+	boot_stock  string = "stock"
+)
+
+var boot_kinds = []string{boot_emummc, boot_sysmmc, boot_stock}
+
+/**
+ * How Atmosphère is loaded, pkg3 is the current name of fss0
+ */
+const (
+	loader_pkg3 string = "pkg3"
+	loader_fss0 string = "fss0"
+)
+
+/**
+ * Defaults of the [config] section when there's no ini yet
+ */
+var hekate_config_defaults = [][2]string{
+	{"autoboot", "0"},
+	{"autoboot_list", "0"},
+	{"bootwait", "3"},
+	{"backlight", "100"},
+	{"noticker", "0"},
+	{"autohosoff", "0"},
+	{"autonogc", "1"},
+	{"updater2p", "0"},
+	{"bootprotect", "0"},
+}
+
+/**
+ * Boot entry of the generated hekate_ipl.ini
+ */
+type BootEntry struct {
+	// emummc, sysmmc or stock
+	Kind string `json:"kind"`
+	// Section name, a default one for the kind if empty
+	Name string `json:"name,omitempty"`
+	// pkg3, fss0, or empty for stock entries that don't load Atmosphère's package
+	Loader    string `json:"loader,omitempty"`
+	Kip1patch bool   `json:"kip1patch,omitempty"`
+	// Relative to the SD root, e.g. bootloader/res/icon_payload.bmp
+	Icon string `json:"icon,omitempty"`
+}
+
+/**
+ * What goes into the generated hekate_ipl.ini
+ */
+type BootConfig struct {
+	Entries []BootEntry `json:"entries"`
+	// Entry booted right away, counting from 1, 0 for none
+	Autoboot int `json:"autoboot"`
+}
+
+/**
+ * Describes a kind of boot entry for the GUI, also the default section name
+ * @param  string kind
+ * @return string
+ */
+func bootKindLabel(kind string) string {
+	switch kind {
+	case boot_emummc:
+		return "CFW (emuMMC)"
+	case boot_sysmmc:
+		return "CFW (sysMMC)"
+	}
+	return "Stock (sysMMC)"
+}
+
+/**
+ * @return string Section name of the entry
+ */
+func (e *BootEntry) name() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return bootKindLabel(e.Kind)
+}
+
+/**
+ * Parses a boot entry as written in the command line, e.g. "emummc,fss0,kip1patch,icon=path"
+ * @param  string spec
+ * @return BootEntry, error
+ */
+func parseBootEntry(spec string) (BootEntry, error) {
+	parts := strings.Split(spec, ",")
+	e := BootEntry{Kind: parts[0], Loader: loader_pkg3}
+
+	if e.Kind == boot_stock {
+		e.Loader = ""
+	}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case loader_pkg3, loader_fss0:
+			e.Loader = key
+		case "kip1patch":
+			e.Kip1patch = true
+		case "icon":
+			e.Icon = value
+		case "name":
+			e.Name = value
+		default:
+			return e, fmt.Errorf("unknown boot entry option %q", part)
+		}
+	}
+
+	return e, e.check()
+}
+
+/**
+ * @return error Why the entry would make an invalid ini
+ */
+func (e *BootEntry) check() error {
+	switch e.Kind {
+	case boot_emummc, boot_sysmmc:
+		if e.Loader != loader_pkg3 && e.Loader != loader_fss0 {
+			return fmt.Errorf("%s entries need %s or %s", e.Kind, loader_pkg3, loader_fss0)
+		}
+	case boot_stock:
+		if e.Loader != "" && e.Loader != loader_pkg3 && e.Loader != loader_fss0 {
+			return fmt.Errorf("unknown loader %q", e.Loader)
+		}
+	default:
+		return fmt.Errorf("boot entry kind must be one of %s", strings.Join(boot_kinds, ", "))
+	}
+
+	if strings.ContainsAny(e.Name, "[]\r\n") || strings.TrimSpace(e.Name) != e.Name {
+		return fmt.Errorf("bad boot entry name %q", e.Name)
+	}
+	if e.Name == "config" {
+		return errors.New("config can't be used as a boot entry name")
+	}
+	if strings.ContainsAny(e.Icon, "\r\n") || (e.Icon != "" && !filepath.IsLocal(filepath.FromSlash(e.Icon))) {
+		return fmt.Errorf("icon %q must be relative to the SD root", e.Icon)
+	}
+
+	return nil
+}
+
+/**
+ * @return error Why the config would make an invalid ini
+ */
+func (bc *BootConfig) check() error {
+	names := map[string]bool{}
+
+	for i := range bc.Entries {
+		e := &bc.Entries[i]
+		if err := e.check(); err != nil {
+			return err
+		}
+		if names[e.name()] {
+			return fmt.Errorf("there are two boot entries named %s", e.name())
+		}
+		names[e.name()] = true
+	}
+
+	if bc.Autoboot < 0 || bc.Autoboot > len(bc.Entries) {
+		return fmt.Errorf("autoboot must be between 0 and %d", len(bc.Entries))
+	}
+
+	return nil
+}
+
+/**
+ * Puts the boot entries into an ini, entries already there keep whatever else they have
+ * @param  *ini_file ini
+ */
+func (bc *BootConfig) merge(ini *ini_file) {
+	config := ini.section("config")
+	if config == nil {
+		// Must be the first section
+		config = &ini_section{name: "config", added: true}
+		for _, pair := range hekate_config_defaults {
+			config.set(pair[0], pair[1])
+		}
+		if len(ini.sections) > 0 {
+			config.lines = append(config.lines, "")
+		}
+		ini.sections = append([]*ini_section{config}, ini.sections...)
+	}
+
+	for i := range bc.Entries {
+		e := &bc.Entries[i]
+		s := ini.ensureSection(e.name())
+
+		// The chosen loader stays where it was, so merging again changes nothing
+		for _, loader := range []string{loader_pkg3, loader_fss0} {
+			if loader != e.Loader {
+				s.remove(loader)
+			}
+		}
+		if e.Loader != "" {
+			s.set(e.Loader, "atmosphere/package3")
+		}
+
+		if e.Kip1patch {
+			s.set("kip1patch", "nosigchk")
+		} else {
+			s.remove("kip1patch")
+		}
+
+		switch e.Kind {
+		case boot_emummc:
+			s.remove("emummc_force_disable")
+			s.remove("stock")
+			s.set("emummcforce", "1")
+		case boot_sysmmc:
+			s.remove("emummcforce")
+			s.remove("stock")
+			s.set("emummc_force_disable", "1")
+		case boot_stock:
+			s.remove("emummcforce")
+			s.set("stock", "1")
+			s.set("emummc_force_disable", "1")
+		}
+
+		if e.Icon != "" {
+			s.set("icon", e.Icon)
+		}
+	}
+
+	// Hekate counts every section but [config], in file order
+	autoboot := 0
+	if bc.Autoboot > 0 {
+		name := bc.Entries[bc.Autoboot-1].name()
+		index := 0
+		for _, s := range ini.sections {
+			if s.name == "config" {
+				continue
+			}
+			index++
+			if s.name == name {
+				autoboot = index
+			}
+		}
+	}
+	config.set("autoboot", fmt.Sprint(autoboot))
+	config.set("autoboot_list", "0")
+}
+
+/**
+ * Writes the boot entries into the hekate_ipl.ini of an output dir, merging them with the one
+ * already there
+ * @param  *BootConfig bc
+ * @param  string      outdir
+ * @return error
+ */
+func (b *Builder) writeHekateIni(bc *BootConfig, outdir string) error {
+	ini_path := filepath.Join(outdir, hekate_ini_path)
+
+	data, err := os.ReadFile(ini_path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	ini := parseIni(data)
+	bc.merge(ini)
+	merged := ini.bytes()
+
+	switch {
+	case os.IsNotExist(err):
+		os.MkdirAll(filepath.Dir(ini_path), os.ModePerm)
+		return os.WriteFile(ini_path, merged, 0644)
+	case bytes.Equal(data, merged):
+		return nil
+	}
+
+	return b.writeMerged(ini_path, merged)
+}
+
+/**
+ * Boot config generation after installing Hekate
+ * @param  context.Context ctx
+ * @param  *Builder        b
+ * @param  string          outdir
+ * @return error           Always nil, failures are warnings
+ */
+func afterHekate(ctx context.Context, b *Builder, outdir string) error {
+	bc := b.settings.Boot
+	if bc == nil || len(bc.Entries) == 0 {
+		return nil
+	}
+
+	// Not tracked as an installed file, it's the user's and updates must never remove it
+	b.step("Hekate", "Writing %s", filepath.ToSlash(hekate_ini_path))
+	if err := bc.check(); err != nil {
+		b.warn("Hekate", "Could not write %s: %s", filepath.ToSlash(hekate_ini_path), err)
+	} else if err = b.writeHekateIni(bc, outdir); err != nil {
+		b.warn("Hekate", "Could not write %s: %s", filepath.ToSlash(hekate_ini_path), err)
+	} else {
+		b.stepDone("Hekate")
+	}
+
+	return nil
+}
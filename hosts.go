@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+/**
+ * Header of the entries added to an existing hosts file
+ */
+const hosts_added_comment string = "# Added by make-nsw-sd"
+
+/**
+ * Splits a hosts file line into its address, host names and trailing comment
+ * @param  string line
+ * @return string   Address, empty for blank and comment lines
+ * @return []string Host names
+ * @return string   Comment, including the #
+ */
+func parseHostsLine(line string) (string, []string, string) {
+	comment := ""
+	if i := strings.Index(line, "#"); i >= 0 {
+		line, comment = line[:i], line[i:]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", nil, comment
+	}
+
+	return fields[0], fields[1:], comment
+}
+
+/**
+ * Adds the entries of a hosts file that another one is missing. Lines already there are kept
+ * in their order, host names already mapped by an earlier line are dropped
+ * @param  string existing Contents of the file on the card
+ * @param  string blocking Contents the file would have had
+ * @return string   Merged contents
+ * @return []string Lines added
+ * @return int      Duplicated host names dropped
+ */
+func mergeHosts(existing string, blocking string) (string, []string, int) {
+	mapped := map[string]bool{}
+	lines := []string{}
+	dropped := 0
+
+	existing = strings.ReplaceAll(existing, "\r\n", "\n")
+	for _, line := range strings.Split(strings.TrimRight(existing, "\n"), "\n") {
+		address, names, comment := parseHostsLine(line)
+		if address == "" {
+			lines = append(lines, line)
+			continue
+		}
+
+		kept := []string{}
+		for _, name := range names {
+			if mapped[strings.ToLower(name)] {
+				dropped++
+				continue
+			}
+			mapped[strings.ToLower(name)] = true
+			kept = append(kept, name)
+		}
+
+		switch {
+		case len(kept) == len(names):
+			// Untouched, spacing and all
+			lines = append(lines, line)
+		case len(kept) > 0:
+			lines = append(lines, strings.TrimSpace(address+" "+strings.Join(kept, " ")+" "+comment))
+		case comment != "":
+			lines = append(lines, comment)
+		}
+	}
+
+	added := []string{}
+	for _, line := range strings.Split(blocking, "\n") {
+		address, names, _ := parseHostsLine(line)
+
+		missing := []string{}
+		for _, name := range names {
+			if !mapped[strings.ToLower(name)] {
+				mapped[strings.ToLower(name)] = true
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			added = append(added, address+" "+strings.Join(missing, " "))
+		}
+	}
+
+	if len(added) > 0 {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, hosts_added_comment)
+		lines = append(lines, added...)
+	}
+
+	return strings.Join(lines, "\n") + "\n", added, dropped
+}
+
+/**
+ * Writes a hosts file into the output dir, one that's already there gets the missing entries
+ * instead of being replaced
+ * @param  string dst
+ * @param  string name     Shown in the report
+ * @param  string blocking Contents of the file to write
+ * @return []string What was changed in an existing file, error
+ */
+func (b *Builder) writeHosts(dst string, name string, blocking string) ([]string, error) {
+	existing, err := os.ReadFile(dst)
+	if os.IsNotExist(err) {
+		return nil, b.writeFile(dst, strings.NewReader(blocking), time.Now())
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Left as the last build wrote it
+	if string(existing) == blocking {
+		sum := sha256.Sum256(existing)
+		b.wrote(dst, hex.EncodeToString(sum[:]))
+		return nil, nil
+	}
+
+	merged, added, dropped := mergeHosts(string(existing), blocking)
+
+	// Shared with the user now, an update never removes it
+	b.wrote(dst, "")
+
+	if len(added) == 0 && dropped == 0 {
+		return []string{name + " already has every entry"}, nil
+	}
+
+	if err = b.writeMerged(dst, []byte(merged)); err != nil {
+		return nil, err
+	}
+
+	report := []string{}
+	for _, line := range added {
+		report = append(report, "Added to "+name+": "+line)
+	}
+	if dropped > 0 {
+		report = append(report, fmt.Sprintf("Removed %d duplicated host names from %s", dropped, name))
+	}
+
+	return report, nil
+}
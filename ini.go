@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+/**
+ * Section of an ini file, its lines are kept as they are so comments survive a rewrite
+ */
+type ini_section struct {
+	name  string
+	lines []string
+	// Not in the file that was read
+	added bool
+}
+
+/**
+ * Ini file that can be changed and written back without losing anything it had
+ */
+type ini_file struct {
+	// Lines before the first section
+	head     []string
+	sections []*ini_section
+}
+
+/**
+ * @param  []byte data
+ * @return *ini_file
+ */
+func parseIni(data []byte) *ini_file {
+	f := &ini_file{}
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return f
+	}
+
+	var current *ini_section
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = &ini_section{name: strings.TrimSpace(trimmed[1 : len(trimmed)-1])}
+			f.sections = append(f.sections, current)
+			continue
+		}
+
+		if current == nil {
+			f.head = append(f.head, line)
+		} else {
+			current.lines = append(current.lines, line)
+		}
+	}
+
+	return f
+}
+
+/**
+ * @param  string name
+ * @return *ini_section Nil if there's none
+ */
+func (f *ini_file) section(name string) *ini_section {
+	for _, s := range f.sections {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+/**
+ * Gets a section, adding it at the end if it's not there
+ * @param  string name
+ * @return *ini_section
+ */
+func (f *ini_file) ensureSection(name string) *ini_section {
+	if s := f.section(name); s != nil {
+		return s
+	}
+
+	s := &ini_section{name: name, added: true}
+	f.sections = append(f.sections, s)
+
+	return s
+}
+
+/**
+ * @return []byte The whole file, added sections are separated from what's before by a blank line
+ */
+func (f *ini_file) bytes() []byte {
+	var buf bytes.Buffer
+
+	for _, line := range f.head {
+		buf.WriteString(line + "\n")
+	}
+
+	for _, s := range f.sections {
+		if s.added && buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n\n")) {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("[" + s.name + "]\n")
+		for _, line := range s.lines {
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+/**
+ * @param  string key
+ * @return int    Index of the key's line, -1 if it's not there
+ */
+func (s *ini_section) find(key string) int {
+	for i, line := range s.lines {
+		if k, _, found := strings.Cut(line, "="); found && strings.TrimSpace(k) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+/**
+ * @param  string key
+ * @return string, bool False if it's not there
+ */
+func (s *ini_section) get(key string) (string, bool) {
+	i := s.find(key)
+	if i < 0 {
+		return "", false
+	}
+	_, value, _ := strings.Cut(s.lines[i], "=")
+	return strings.TrimSpace(value), true
+}
+
+/**
+ * Changes a value in place, new keys go after the last key of the section
+ * @param string key
+ * @param string value
+ */
+func (s *ini_section) set(key string, value string) {
+	line := key + "=" + value
+
+	if i := s.find(key); i >= 0 {
+		s.lines[i] = line
+		return
+	}
+
+	// Trailing blank lines and comments belong to what comes next
+	at := len(s.lines)
+	for at > 0 && !strings.Contains(s.lines[at-1], "=") {
+		at--
+	}
+	s.lines = slices.Insert(s.lines, at, line)
+}
+
+/**
+ * @param string key
+ */
+func (s *ini_section) remove(key string) {
+	if i := s.find(key); i >= 0 {
+		s.lines = slices.Delete(s.lines, i, i+1)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/**
+ * Version header magics in the Hekate and Nyx binaries, followed by major, minor and hotfix
+ * numbers stored as '0' + n
+ */
+var (
+	hekate_magic = []byte("ICTC")
+	nyx_magic    = []byte("XCTC")
+)
+
+/**
+ * Magic of Atmosphère's package3
+ */
+const package3_magic string = "PK31"
+
+/**
+ * How much of the start of a package3 is read looking for its version
+ */
+const package3_header_size int = 0x100
+
+/**
+ * Where the display version is inside a NACP
+ */
+const (
+	nacp_version_offset int64 = 0x3060
+	nacp_version_size   int   = 0x10
+)
+
+/**
+ * Installed and latest version of a component on an SD card
+ */
+type version_status struct {
+	c *component
+	// Empty if it couldn't be found out
+	version string
+	// More details about what's installed, e.g. the Nyx version
+	extra  string
+	latest string
+	// Installed and either older than latest or of unknown version
+	outdated bool
+	// Version is the newest firmware in patches.ini instead of a release, SPs only
+	sps_firmware bool
+}
+
+/**
+ * Finds the version header of a Hekate or Nyx binary
+ * @param  string file_path
+ * @param  []byte magic
+ * @return string Formatted as {major}.{minor}.{hotfix}, error
+ */
+func readCtcaerVersion(file_path string, magic []byte) (string, error) {
+	data, err := os.ReadFile(file_path)
+	if err != nil {
+		return "", err
+	}
+
+	for start := 0; ; {
+		i := bytes.Index(data[start:], magic)
+		if i < 0 {
+			return "", errors.New("no version header found")
+		}
+		i += start + len(magic)
+
+		if i+3 <= len(data) {
+			numbers := data[i : i+3]
+			valid := true
+			for _, n := range numbers {
+				// Anything else is just the same bytes by chance
+				if n < '0' || n > '0'+99 {
+					valid = false
+				}
+			}
+			if valid {
+				return fmt.Sprintf("%d.%d.%d", numbers[0]-'0', numbers[1]-'0', numbers[2]-'0'), nil
+			}
+		}
+
+		start = i
+	}
+}
+
+/**
+ * Reads the display version from the NACP in the assets of a homebrew NRO
+ * @param  string file_path
+ * @return string, error
+ */
+func readNroVersion(file_path string) (string, error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// NRO header comes after the start of the code
+	header := make([]byte, 0x20)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		return "", err
+	}
+	if string(header[0x10:0x14]) != "NRO0" {
+		return "", errors.New("not an NRO file")
+	}
+	assets_offset := int64(binary.LittleEndian.Uint32(header[0x18:]))
+
+	// Assets header: magic, version, then icon, NACP and RomFS as offset and size pairs
+	assets := make([]byte, 0x38)
+	if _, err = file.ReadAt(assets, assets_offset); err != nil {
+		return "", errors.New("no assets found")
+	}
+	if string(assets[:4]) != "ASET" {
+		return "", errors.New("no assets found")
+	}
+	nacp_offset := int64(binary.LittleEndian.Uint64(assets[0x18:]))
+	nacp_size := int64(binary.LittleEndian.Uint64(assets[0x20:]))
+	if nacp_size < nacp_version_offset+int64(nacp_version_size) {
+		return "", errors.New("no NACP found")
+	}
+
+	version := make([]byte, nacp_version_size)
+	if _, err = file.ReadAt(version, assets_offset+nacp_offset+nacp_version_offset); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return string(bytes.TrimRight(version, "\x00")), nil
+}
+
+/**
+ * Reads the Atmosphère version from the header of a package3. As written by Atmosphère's
+ * build_package3.py, the magic is followed by the metadata offset, then the metadata has the git
+ * revision, the major, minor, micro and relstep numbers, and the newest supported firmware the
+ * same way. Older builds have the metadata right after the first 16 bytes
+ * @param  string file_path
+ * @return string Formatted as {major}.{minor}.{micro}
+ * @return string Newest supported firmware, same format, error
+ */
+func readPackage3Version(file_path string) (string, string, error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, package3_header_size)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", "", err
+	}
+	header = header[:n]
+
+	if n < 0x20 || string(header[:4]) != package3_magic {
+		return "", "", errors.New("not a package3 file")
+	}
+
+	for _, offset := range []int{int(binary.LittleEndian.Uint32(header[4:])), 0x10} {
+		if offset < 8 || offset+12 > len(header) {
+			continue
+		}
+		version, firmware := header[offset+4:offset+7], header[offset+8:offset+11]
+
+		// Anything else isn't the metadata
+		if version[0] > 9 || version[1] > 99 || version[2] > 99 || version[0]+version[1]+version[2] == 0 {
+			continue
+		}
+		if firmware[0] == 0 || firmware[0] > 99 || firmware[1] > 99 || firmware[2] > 99 {
+			continue
+		}
+
+		return fmt.Sprintf("%d.%d.%d", version[0], version[1], version[2]),
+			fmt.Sprintf("%d.%d.%d", firmware[0], firmware[1], firmware[2]), nil
+	}
+
+	return "", "", errors.New("no version found in the package3 header")
+}
+
+/**
+ * Reads the installed Atmosphère version
+ * @param  string sd_root
+ * @return string Version, string Extra details, error
+ */
+func readAtmosphereVersion(sd_root string) (string, string, error) {
+	version, firmware, err := readPackage3Version(filepath.Join(sd_root, "atmosphere", "package3"))
+	if err != nil {
+		return "", "", err
+	}
+	return version, "firmware up to " + firmware, nil
+}
+
+/**
+ * Reads the newest firmware the installed SPs have patches for, SPs have no version of their own
+ * @param  string sd_root
+ * @return string Firmware version, error
+ */
+func readSPsFirmware(sd_root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(sd_root, "bootloader", "patches.ini"))
+	if err != nil {
+		return "", err
+	}
+
+	firmware := patchesIniFirmware(bytes.NewReader(data))
+	if firmware == "" {
+		return "", errors.New("no firmware versions in patches.ini")
+	}
+	return firmware, nil
+}
+
+/**
+ * Reads the installed Hekate version, along with the Nyx one
+ * @param  string sd_root
+ * @return string Version, string Extra details, error
+ */
+func readHekateVersion(sd_root string) (string, string, error) {
+	version, err := readCtcaerVersion(filepath.Join(sd_root, "bootloader", "update.bin"), hekate_magic)
+	if err != nil {
+		return "", "", err
+	}
+
+	extra := ""
+	if nyx, err := readCtcaerVersion(filepath.Join(sd_root, "bootloader", "sys", "nyx.bin"), nyx_magic); err == nil {
+		extra = "Nyx " + nyx
+	}
+
+	return version, extra, nil
+}
+
+/**
+ * Reads the installed DBI version
+ * @param  string sd_root
+ * @return string Version, string Extra details, error
+ */
+func readDbiVersion(sd_root string) (string, string, error) {
+	version, err := readNroVersion(filepath.Join(sd_root, "switch", "DBI", "DBI.nro"))
+	return version, "", err
+}
+
+/**
+ * Gets the newest version of a component according to the version settings
+ * @param  context.Context ctx
+ * @param  *component      c
+ * @param  *Settings       settings
+ * @return string Release tag or file name, empty if there's no way to know, error
+ */
+func latestVersion(ctx context.Context, c *component, settings *Settings) (string, error) {
+	switch c.source {
+	case sourceGitHub, sourceGitea:
+		release, _, err := resolveRelease(ctx, c.repo, settings.version(c.id), settings.order(c.id), c.api_url)
+		if err != nil {
+			return "", err
+		}
+		return release.TagName, nil
+	case sourceForum:
+		// The user's own SPs have no newer version
+		if settings.LocalSPs != "" {
+			return "", nil
+		}
+		return latestSPsVersion(ctx, settings)
+	}
+	return "", nil
+}
+
+/**
+ * Tells if an installed version is older than the latest one
+ * @param  string installed Empty if unknown
+ * @param  string latest    Empty if unknown
+ * @return bool
+ */
+func isOutdated(installed string, latest string) bool {
+	if latest == "" {
+		return false
+	}
+	if installed == "" {
+		return true
+	}
+
+	installed_ver, installed_ok := parseSemver(installed)
+	latest_ver, latest_ok := parseSemver(latest)
+	if installed_ok && latest_ok {
+		return installed_ver.compare(latest_ver) < 0
+	}
+
+	// File names and such can only be the same or not
+	return !strings.EqualFold(strings.TrimPrefix(installed, "v"), strings.TrimPrefix(latest, "v"))
+}
+
+/**
+ * Finds out which components are on an SD card, their versions and if there are newer ones.
+ * Versions are read from the files when possible, from the card's lockfile otherwise
+ * @param  context.Context ctx
+ * @param  string          sd_root
+ * @param  *Settings       settings
+ * @return []*version_status Only for the installed components, in registry order
+ */
+func detectVersions(ctx context.Context, sd_root string, settings *Settings) []*version_status {
+	previous, dos, err := detectInstalled(sd_root)
+	if err != nil {
+		return nil
+	}
+
+	statuses := []*version_status{}
+
+	for _, c := range components {
+		if !dos[c.id] {
+			continue
+		}
+
+		s := &version_status{c: c}
+
+		if c.read_version != nil {
+			s.version, s.extra, _ = c.read_version(sd_root)
+		}
+		if s.version == "" && previous != nil {
+			if lc := previous.component(c.id); lc != nil {
+				s.version = lc.Tag
+				if s.version == "" && c.source == sourceForum && len(lc.Assets) > 0 {
+					s.version = lc.Assets[0].File
+				}
+			}
+		}
+		// Cards not built by this tool still tell which firmware their SPs are for
+		if s.version == "" && c.source == sourceForum {
+			if firmware, err := readSPsFirmware(sd_root); err == nil {
+				s.version, s.extra, s.sps_firmware = firmware, "firmware in patches.ini", true
+			}
+		}
+
+		statuses = append(statuses, s)
+	}
+
+	// Latest versions are looked up at the same time
+	var wg sync.WaitGroup
+
+	for _, s := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.latest, _ = latestVersion(ctx, s.c, settings)
+			s.outdated = isOutdated(s.version, s.latest)
+			// Only comparable with the firmware in the latest SPs' name
+			if _, firmware := parseSPsFileName(s.latest); s.sps_firmware && firmware != "" {
+				s.outdated = isOutdated(s.version, firmware)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return statuses
+}
+
+/**
+ * Describes the installed version against the latest one
+ * @return string
+ */
+func (s *version_status) note() string {
+	version := s.version
+	if version == "" {
+		version = "installed"
+	}
+	if s.extra != "" {
+		version += ", " + s.extra
+	}
+
+	switch {
+	case s.latest == "":
+		return version
+	case s.outdated:
+		return version + " → " + s.latest
+	}
+	return version + ", latest"
+}
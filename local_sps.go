@@ -0,0 +1,366 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/**
+ * Paths that tell a zip or folder has SPs in it, relative to the SD root
+ */
+var sps_markers = []string{"atmosphere/exefs_patches/", "atmosphere/kip_patches/", "bootloader/patches.ini"}
+
+/**
+ * Folders where patches go in subfolders, one per patched program
+ */
+var sps_patch_dirs = []string{"atmosphere/exefs_patches/", "atmosphere/kip_patches/"}
+
+/**
+ * Checks that a list of files is laid out like SPs, relative to the SD root
+ * @param  []string names Slash-separated file paths
+ * @return []string Files that aren't part of an SPs layout, they're copied anyway
+ * @return error    Why they can't be SPs
+ */
+func checkSPsLayout(names []string) ([]string, error) {
+	found := false
+	extra := []string{}
+	bad := []string{}
+	tops := map[string]bool{}
+
+	for _, name := range names {
+		top, _, _ := strings.Cut(name, "/")
+		tops[top] = true
+
+		for _, marker := range sps_markers {
+			if name == marker || strings.HasPrefix(name, marker) {
+				found = true
+			}
+		}
+
+		for _, dir := range sps_patch_dirs {
+			// Hidden files like .DS_Store are harmless
+			if rest, ok := strings.CutPrefix(name, dir); ok && !strings.HasPrefix(path.Base(rest), ".") {
+				// {program}/{build id}.ips
+				if parts := strings.Split(rest, "/"); len(parts) != 2 || !strings.EqualFold(path.Ext(rest), ".ips") {
+					bad = append(bad, name)
+				}
+			}
+		}
+
+		if top != "atmosphere" && top != "bootloader" {
+			extra = append(extra, name)
+		}
+	}
+
+	if !found {
+		// Zipped with the folder they were in
+		if len(tops) == 1 {
+			for top := range tops {
+				return nil, fmt.Errorf("everything is inside %s/, atmosphere and bootloader must be at the top", top)
+			}
+		}
+		return nil, fmt.Errorf("none of %s found", strings.Join(sps_markers, ", "))
+	}
+
+	if len(bad) > 0 {
+		if len(bad) > 3 {
+			bad = append(bad[:3], fmt.Sprintf("%d more", len(bad)-3))
+		}
+		return nil, fmt.Errorf("patches must be .ips files in a subfolder of %s: %s", strings.Join(sps_patch_dirs, " or "), strings.Join(bad, ", "))
+	}
+
+	return extra, nil
+}
+
+/**
+ * Lists the files in a zip
+ * @param  string zip_path
+ * @return []string Slash-separated, folders left out, error
+ */
+func zipFileNames(zip_path string) ([]string, error) {
+	r, err := zip.OpenReader(zip_path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	names := []string{}
+	for _, file := range r.File {
+		if !file.FileInfo().IsDir() {
+			names = append(names, file.Name)
+		}
+	}
+
+	return names, nil
+}
+
+/**
+ * Lists the files in a folder
+ * @param  string dir
+ * @return []string Slash-separated and relative to the folder, error
+ */
+func dirFileNames(dir string) ([]string, error) {
+	names := []string{}
+
+	err := filepath.WalkDir(dir, func(file_path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file_path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return names, err
+}
+
+/**
+ * Checks that a local zip or folder can be used as the SPs
+ * @param  string local_path
+ * @return []string Files that aren't part of an SPs layout, error
+ */
+func checkLocalSPs(local_path string) ([]string, error) {
+	info, err := os.Stat(local_path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if info.IsDir() {
+		names, err = dirFileNames(local_path)
+	} else {
+		names, err = zipFileNames(local_path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err := checkSPsLayout(names)
+	if err != nil {
+		return nil, fmt.Errorf("%s doesn't look like SPs: %s", local_path, err)
+	}
+
+	return extra, nil
+}
+
+/**
+ * Zips a folder, symlinks and anything else that's not a regular file are left out
+ * @param  string dir
+ * @param  string zip_path
+ * @return error
+ */
+func zipDir(dir string, zip_path string) error {
+	out, err := os.Create(zip_path)
+	if err != nil {
+		return err
+	}
+
+	w := zip.NewWriter(out)
+
+	err = filepath.WalkDir(dir, func(file_path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, file_path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+
+		dst, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(file_path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(dst, src)
+		return err
+	})
+
+	if close_err := w.Close(); err == nil {
+		err = close_err
+	}
+	if close_err := out.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(zip_path)
+	}
+
+	return err
+}
+
+/**
+ * Puts the user's own SPs into the workdir as a zip, so they're installed and recorded in the
+ * lockfile as the downloaded ones
+ * @param  string local_path Zip file or folder
+ * @return *Asset, error
+ */
+func (b *Builder) getLocalSPs(local_path string) (*Asset, error) {
+	abs, err := filepath.Abs(local_path)
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err := checkLocalSPs(abs)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra) > 0 {
+		slices.Sort(extra)
+		b.warn("SPs", "Not part of an SPs layout, copied anyway: %s", strings.Join(extra, ", "))
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	file_url := &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	if !strings.HasPrefix(file_url.Path, "/") {
+		// C:/folder on Windows
+		file_url.Path = "/" + file_url.Path
+	}
+
+	asset := &Asset{File: filepath.Base(abs), Url: file_url.String()}
+	if info.IsDir() {
+		asset.File += ".zip"
+	}
+
+	b.info("SPs", "Using %s instead of downloading them", abs)
+
+	os.MkdirAll(workdir, os.ModePerm)
+
+	switch dst, _ := filepath.Abs(asset.path()); {
+	case info.IsDir():
+		err = zipDir(abs, asset.path())
+	case dst != abs:
+		err = copyLocalFile(abs, asset.path())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not put %s into %s: %w", abs, workdir, err)
+	}
+
+	return asset, nil
+}
+
+/**
+ * Gets the local path of a file:// URL, as written by getLocalSPs
+ * @param  string asset_url
+ * @return string Empty if it's not a file:// URL
+ */
+func localAssetPath(asset_url string) string {
+	u, err := url.Parse(asset_url)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	local_path := u.Path
+	// file:///C:/folder on Windows
+	if len(local_path) > 2 && local_path[0] == '/' && local_path[2] == ':' {
+		local_path = local_path[1:]
+	}
+	return filepath.FromSlash(local_path)
+}
+
+/**
+ * Puts a local file from a lockfile back into the workdir, for rebuilds made with the user's own
+ * SPs. The workdir copy is used if it's still there, the original zip or folder otherwise, and
+ * either must have the SHA-256 in the lockfile
+ * @param  string component Component name for the log
+ * @param  *Asset asset
+ * @return error
+ */
+func (b *Builder) getLocalAsset(component string, asset *Asset) error {
+	if _, sha, err := hashFile(asset.path()); err == nil && sha == asset.expected {
+		b.info(component, "%s already exists, SHA-256 matches %s", asset.File, asset.expected_from)
+		return nil
+	}
+
+	src := localAssetPath(asset.Url)
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("%s was made from %s, which can't be read: %w", asset.File, src, err)
+	}
+
+	os.MkdirAll(workdir, os.ModePerm)
+
+	if info.IsDir() {
+		err = zipDir(src, asset.path())
+	} else {
+		err = copyLocalFile(src, asset.path())
+	}
+	if err != nil {
+		return fmt.Errorf("could not put %s into %s: %w", src, workdir, err)
+	}
+
+	_, sha, err := hashFile(asset.path())
+	if err == nil && sha != asset.expected {
+		err = fmt.Errorf("%s changed since the build, SHA-256 is %s but %s says %s", src, sha, asset.expected_from, asset.expected)
+	}
+	if err != nil {
+		os.Remove(asset.path())
+		return err
+	}
+	b.info(component, "Using %s, SHA-256 matches %s", src, asset.expected_from)
+
+	return nil
+}
+
+/**
+ * @param  string src
+ * @param  string dst
+ * @return error
+ */
+func copyLocalFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if close_err := out.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+
+	return err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+/**
+ * File name of the lockfile written into every output dir
+ */
+const lockfile_name string = "make-nsw-sd.lock.json"
+
+/**
+ * Downloaded file
+ */
+type Asset struct {
+	// Name inside the workdir
+	File   string `json:"file"`
+	Url    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// SHA-256 the file must have, when known
+	expected string
+	// Where the expected SHA-256 comes from, for the log
+	expected_from string
+}
+
+/**
+ * File put into the output dir by a component
+ */
+type InstalledFile struct {
+	// Slash-separated and relative to the output dir
+	Path string `json:"path"`
+	// Empty if the file was already there and left alone
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+/**
+ * What was downloaded and installed for a component
+ */
+type LockComponent struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Tag  string `json:"tag,omitempty"`
+	// Release date, nil if it's not from a release
+	Published *time.Time       `json:"published,omitempty"`
+	Assets    []*Asset         `json:"assets"`
+	Files     []*InstalledFile `json:"files,omitempty"`
+}
+
+/**
+ * Record of exactly what went into a build
+ */
+type Lockfile struct {
+	Created time.Time `json:"created"`
+	// Every selected component id, including the ones without downloads
+	Selected   []string         `json:"selected"`
+	Components []*LockComponent `json:"components"`
+}
+
+/**
+ * @return string Where the asset is in the workdir
+ */
+func (a *Asset) path() string {
+	return filepath.Join(workdir, a.File)
+}
+
+/**
+ * Tells if a name can be used as is for a file in the workdir, without pointing anywhere else
+ * @param  string name
+ * @return bool
+ */
+func isPlainFileName(name string) bool {
+	return name != "." && !strings.ContainsAny(name, `/\`) && filepath.IsLocal(name)
+}
+
+/**
+ * Gets the size and SHA-256 of a file
+ * @param  string file_path
+ * @return int64, string, error
+ */
+func hashFile(file_path string) (int64, string, error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+/**
+ * Finds the entry of a component
+ * @param  string id
+ * @return *LockComponent Nil if not found
+ */
+func (l *Lockfile) component(id string) *LockComponent {
+	for _, lc := range l.Components {
+		if lc.Id == id {
+			return lc
+		}
+	}
+	return nil
+}
+
+/**
+ * Writes the lockfile into an output dir
+ * @param  string outdir
+ * @return error
+ */
+func (l *Lockfile) write(outdir string) error {
+	data, err := jsoniter.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outdir, lockfile_name), data, 0644)
+}
+
+/**
+ * Reads a lockfile, every component in it must be known
+ * @param  string file_path
+ * @return *Lockfile, error
+ */
+func readLockfile(file_path string) (*Lockfile, error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lock Lockfile
+
+	if err = jsoniter.NewDecoder(file).Decode(&lock); err != nil {
+		return nil, err
+	}
+
+	for _, id := range lock.Selected {
+		if getComponent(id) == nil {
+			return nil, fmt.Errorf("unknown component %s", id)
+		}
+	}
+
+	// Files are saved into the workdir by name, a shared lockfile must not point anywhere else
+	for _, lc := range lock.Components {
+		if lc == nil {
+			return nil, errors.New("empty component entry")
+		}
+		for _, asset := range lc.Assets {
+			if asset == nil {
+				return nil, fmt.Errorf("%s: empty asset entry", lc.Id)
+			}
+			if !isPlainFileName(asset.File) {
+				return nil, fmt.Errorf("%s: %q is not a plain file name", lc.Id, asset.File)
+			}
+			// Without it the rebuild would install whatever the URL serves now
+			if !sha256_re.MatchString(asset.Sha256) {
+				return nil, fmt.Errorf("%s: %s has no valid SHA-256", lc.Id, asset.File)
+			}
+			asset.Sha256 = strings.ToLower(asset.Sha256)
+		}
+	}
+
+	return &lock, nil
+}
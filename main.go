@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"image/color"
+	"os"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -12,28 +15,11 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
-/**
- * Custom type for the actions that are going to be done
- */
-type dos_type struct {
-	atmosphere bool
-	hekate     bool
-	payload    bool
-	bootdat    bool
-	lockpick   bool
-	sps        bool
-	dbi        bool
-}
-
-/**
- * Make custom insert-to-log function available outside main
- */
-var log_add func(string)
-
 /**
  * Custom fyne widget, icon next to a small bold text
  * @param  fyne.Resource icon
@@ -62,6 +48,96 @@ func newOutdir() string {
  * Program entry point
  */
 func main() {
+	// User-defined components must be in the registry before making flags and check boxes
+	manifest_err := loadManifests()
+	settings, settings_err := loadSettings()
+
+	opts, err := parseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	if opts.workdir != "" {
+		workdir = opts.workdir
+	}
+
+	// Choices made from the command line are kept for the next builds
+	if opts.boot_entries != nil || opts.autoboot >= 0 {
+		boot := &BootConfig{}
+		if settings.Boot != nil {
+			*boot = *settings.Boot
+		}
+		if opts.boot_entries != nil {
+			boot.Entries = opts.boot_entries
+		}
+		if opts.autoboot >= 0 {
+			boot.Autoboot = opts.autoboot
+		}
+		if err := boot.check(); err != nil {
+			fmt.Fprintf(os.Stderr, "! %s\n", err)
+			os.Exit(2)
+		}
+		settings.Boot = boot
+	}
+
+	if len(opts.exosphere) > 0 {
+		if settings.Exosphere == nil {
+			settings.Exosphere = &ExosphereConfig{}
+		}
+		for _, pair := range opts.exosphere {
+			settings.Exosphere.set(pair[0], pair[1])
+		}
+		if settings.Exosphere.empty() {
+			settings.Exosphere = nil
+		}
+	}
+
+	if len(opts.versions) > 0 || len(opts.orders) > 0 || opts.conflicts != "" || opts.local_sps != nil || opts.sps_sources != nil || opts.sps_check != "" || opts.ban_profile != "" || len(opts.exosphere) > 0 || opts.boot_entries != nil || opts.autoboot >= 0 {
+		for id, version := range opts.versions {
+			settings.Versions[id] = version
+		}
+		for id, order := range opts.orders {
+			settings.Orders[id] = order
+		}
+		if opts.conflicts != "" {
+			settings.Conflicts = opts.conflicts
+		}
+		if opts.local_sps != nil {
+			settings.LocalSPs = *opts.local_sps
+		}
+		if opts.sps_sources != nil {
+			settings.SPsSources = opts.sps_sources
+		}
+		if opts.sps_check != "" {
+			settings.SPsCheck = opts.sps_check
+		}
+		if opts.ban_profile != "" {
+			settings.BanProfile = opts.ban_profile
+		}
+		if err := settings.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "! Could not save settings: %s\n", err)
+		}
+	}
+
+	// Only for this run, never saved
+	if opts.retries >= 0 {
+		settings.retries_override = &opts.retries
+	}
+
+	// No window at all for build servers
+	if opts.headless {
+		if manifest_err != nil {
+			fmt.Fprintf(os.Stderr, "! Could not load extra components: %s\n", manifest_err)
+			os.Exit(2)
+		}
+		if settings_err != nil {
+			fmt.Fprintf(os.Stderr, "! Could not load settings, using defaults: %s\n", settings_err)
+		}
+		os.Exit(runHeadless(opts, settings))
+	}
+
 	// Create GUI application
 	a := app.New()
 	// Custom theme to make text a little bit smaller and workaround lack of read-only inputs
@@ -75,65 +151,46 @@ func main() {
 
 	// Create output dir name
 	folder_entry_data := binding.NewString()
-	folder_entry_data.Set(newOutdir())
+	if opts.outdir != "" {
+		folder_entry_data.Set(opts.outdir)
+	} else {
+		folder_entry_data.Set(newOutdir())
+	}
 	folder_entry := widget.NewEntryWithData(folder_entry_data)
 	folder_entry.Disable()
 
 	/* Create check boxes for the what-to-do actions */
 
-	atmosphere_check := widget.NewCheck("Atmosphère", nil)
-	atmosphere_check.Checked = true
+	check_rows, checks_data, checks := newComponentChecks()
 
-	// Actions containers to be able to be hidden when Hekate is unchecked
-	var hekate_row_1 *fyne.Container
-	var hekate_row_2 *fyne.Container
-
-	hekate_check := widget.NewCheck("Hekate", func(b bool) {
-		if b {
-			hekate_row_1.Show()
-			hekate_row_2.Show()
-		} else {
-			hekate_row_1.Hide()
-			hekate_row_2.Hide()
+	// Shows what's already in the output folder next to each check box and selects only what's
+	// outdated, a folder without known components gets the defaults back
+	showInstalled := func(outdir string) {
+		statuses := map[string]*version_status{}
+		for _, s := range detectVersions(context.Background(), outdir, settings) {
+			statuses[s.c.id] = s
 		}
-	})
-	hekate_check.Checked = true
 
-	// Add semi-radio button behavior to the payload and boot.dat checks
-	payload_check_data := binding.NewBool()
-	bootdat_check_data := binding.NewBool()
+		for _, c := range components {
+			s := statuses[c.id]
 
-	payload_check_data.AddListener(binding.NewDataListener(func() {
-		if checked, _ := payload_check_data.Get(); checked {
-			if checked, _ = bootdat_check_data.Get(); checked {
-				bootdat_check_data.Set(false)
+			checks[c.id].Text = c.label
+			if s != nil {
+				checks[c.id].Text += " (" + s.note() + ")"
 			}
-		}
-	}))
+			checks[c.id].Refresh()
 
-	bootdat_check_data.AddListener(binding.NewDataListener(func() {
-		if checked, _ := bootdat_check_data.Get(); checked {
-			if checked, _ = payload_check_data.Get(); checked {
-				payload_check_data.Set(false)
+			if len(statuses) > 0 {
+				checks_data[c.id].Set(s != nil && s.outdated)
+			} else {
+				checks_data[c.id].Set(c.checked)
 			}
 		}
-	}))
-
-	payload_check := widget.NewCheckWithData("payload.bin from Hekate", payload_check_data)
-	bootdat_check := widget.NewCheckWithData("boot.dat from SX Gear", bootdat_check_data)
-	lockpick_check := widget.NewCheck("Lockpick_RCM", nil)
-
-	// Spacer text widget
-	emsps := canvas.NewText("  ", color.Transparent)
-
-	// These can be hidden
-	hekate_row_1 = container.NewHBox(emsps, payload_check, bootdat_check)
-	hekate_row_2 = container.NewHBox(emsps, lockpick_check)
-
-	sps_check := widget.NewCheck("SPs", nil)
-	sps_check.Checked = true
+	}
 
-	dbi_check := widget.NewCheck("DBI", nil)
+	if opts.outdir != "" {
+		go showInstalled(opts.outdir)
+	}
 
 	/* App containers */
 
@@ -144,19 +201,46 @@ func main() {
 		w.SetContent(home_container)
 	})
 
+	// Stops the running build
+	var cancel_build context.CancelFunc
+
+	var log_txt_cancel *widget.Button
+	log_txt_cancel = widget.NewButton("Cancel", func() {
+		log_txt_cancel.Disable()
+		cancel_build()
+	})
+	log_txt_cancel.Disable()
+
 	log_txt := widget.NewTextGrid()
 	log_txt_scroll := container.NewScroll(log_txt)
 
-	// This one will be available outside main
-	log_add = func(txt string) {
+	// Build events end up as text in the log
+	log_sink := newTextSink(func(txt string) {
 		log_txt.SetText(log_txt.Text() + txt)
 		log_txt_scroll.ScrollToBottom()
-	}
+	})
+
+	// Download bars go above the log
+	progress := newProgressPanel()
+
+	builder := NewBuilder(EventSinkFunc(func(e Event) {
+		log_sink.Emit(e)
+		progress.Emit(e)
+
+		if e.Kind == EventFinished {
+			// Set new output directory just in case
+			if e.Err == nil {
+				folder_entry_data.Set(newOutdir())
+			}
+			log_txt_cancel.Disable()
+			log_txt_close.Enable()
+		}
+	}), settings)
 
 	// This one will be shown just before process starts
 	log_container := container.NewBorder(
-		nil,
-		container.NewCenter(log_txt_close),
+		progress.container,
+		container.NewCenter(container.NewHBox(log_txt_cancel, log_txt_close)),
 		nil,
 		nil,
 		log_txt_scroll,
@@ -164,38 +248,82 @@ func main() {
 
 	/* Action buttons */
 
-	// Just in case someone clicks on Start with no check boxes on
-	ntd_err := errors.New(" Nothing to do! ")
+	// Shows the log and runs a build in the background until it's finished or canceled
+	startBuild := func(build func(ctx context.Context)) {
+		// Avoid closing log when processing
+		log_txt_close.Disable()
+		progress.reset()
+
+		// Show log
+		w.SetContent(log_container)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel_build = cancel
+		log_txt_cancel.Enable()
+
+		go func() {
+			defer cancel()
+			build(ctx)
+		}()
+	}
 
 	// This one does all the magic
 	start_btn := widget.NewButton("Start", func() {
-		if !atmosphere_check.Checked &&
-			!hekate_check.Checked &&
-			!sps_check.Checked &&
-			!dbi_check.Checked {
-			dialog.ShowError(ntd_err, w)
+		dos := dos_type{}
+		for id, data := range checks_data {
+			dos[id], _ = data.Get()
+		}
+
+		if err := dos.check(); err != nil {
+			dialog.ShowError(err, w)
 			return
 		}
 
-		// Avoid closing log when processing
-		log_txt_close.Disable()
+		// Start process
+		outdir, _ := folder_entry_data.Get()
 
-		// Show log
-		w.SetContent(log_container)
+		startBuild(func(ctx context.Context) {
+			builder.Run(ctx, dos, outdir)
+		})
+	})
 
-		// Start process
-		do_payload, _ := payload_check_data.Get()
-		do_bootdat, _ := bootdat_check_data.Get()
-
-		go start(dos_type{
-			atmosphere: atmosphere_check.Checked,
-			hekate:     hekate_check.Checked,
-			payload:    do_payload,
-			bootdat:    do_bootdat,
-			lockpick:   lockpick_check.Checked,
-			sps:        sps_check.Checked,
-			dbi:        dbi_check.Checked,
-		}, folder_entry_data, log_txt_close)
+	// Updates the components already on an SD card, the selected ones don't matter
+	update_btn := widget.NewButton("Update…", func() {
+		dialog.ShowFolderOpen(func(list fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if list == nil {
+				return
+			}
+
+			startBuild(func(ctx context.Context) {
+				builder.Update(ctx, list.Path())
+			})
+		}, w)
+	})
+
+	// Same as Start but with the exact files of a previous build
+	rebuild_btn := widget.NewButton("Rebuild…", func() {
+		lock_dialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			reader.Close()
+
+			outdir, _ := folder_entry_data.Get()
+
+			startBuild(func(ctx context.Context) {
+				builder.RunLockfile(ctx, reader.URI().Path(), outdir)
+			})
+		}, w)
+		lock_dialog.SetFilter(storage.NewExtensionFileFilter([]string{".json"}))
+		lock_dialog.Show()
 	})
 
 	// Button to choose another output folder
@@ -207,12 +335,19 @@ func main() {
 			}
 			if list == nil {
 				folder_entry_data.Set(newOutdir())
+				go showInstalled("")
 				return
 			}
 			folder_entry_data.Set(list.Path())
+			go showInstalled(list.Path())
 		}, w)
 	})
 
+	// Button to choose which releases are used and what to do with existing files
+	options_btn := widget.NewButtonWithIcon("Options", theme.SettingsIcon(), func() {
+		showOptionsDialog(settings, w)
+	})
+
 	/* Put everything together */
 
 	// Get the right color for the custom text widget depending if it's light or dark
@@ -225,12 +360,11 @@ func main() {
 		container.NewVBox(
 			widget.NewSeparator(),
 			container.NewGridWithColumns(
-				5,
-				emsps,
+				4,
 				start_btn,
-				emsps,
+				update_btn,
+				rebuild_btn,
 				widget.NewButton("Quit", w.Close),
-				emsps,
 			),
 		),
 		// Left
@@ -242,22 +376,20 @@ func main() {
 			myTitle(theme.FolderOpenIcon(), "Output folder", fg_color),
 			container.NewBorder(nil, nil, nil, browse_btn, folder_entry),
 			widget.NewSeparator(),
-			myTitle(theme.DownloadIcon(), "Download & extract latest…", fg_color),
+			container.NewBorder(nil, nil, nil, options_btn, myTitle(theme.DownloadIcon(), "Download & extract…", fg_color)),
 			// Checkboxes container without inner vertical padding
-			container.New(
-				newMyLayout(),
-				atmosphere_check,
-				hekate_check,
-				hekate_row_1,
-				hekate_row_2,
-				sps_check,
-				dbi_check,
-			),
+			container.New(newMyLayout(), check_rows...),
 		),
 	)
 
 	// Show what we built 🙂
 	w.SetContent(home_container)
 	w.CenterOnScreen()
+	if manifest_err != nil {
+		dialog.ShowError(fmt.Errorf("Could not load extra components: %s", manifest_err), w)
+	}
+	if settings_err != nil {
+		dialog.ShowError(fmt.Errorf("Could not load settings, using defaults: %s", settings_err), w)
+	}
 	w.ShowAndRun()
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+/**
+ * File name of the user-defined components manifest
+ */
+const manifest_name string = "make-nsw-sd.json"
+
+/**
+ * Extra component as written in the manifest
+ */
+type ManifestComponent struct {
+	Id    string `json:"id"`
+	Label string `json:"label"`
+	// GitHub repo formatted as {author}/{repo}, or Gitea repo if ApiUrl is set
+	Repo   string `json:"repo"`
+	ApiUrl string `json:"api_url"`
+	// Plain file URL, instead of Repo
+	Url    string `json:"url"`
+	Filter string `json:"filter"`
+	// "extract" or "move"
+	Install    string   `json:"install"`
+	SkipPrefix string   `json:"skip_prefix"`
+	Dest       string   `json:"dest"`
+	DestName   string   `json:"dest_name"`
+	Checked    bool     `json:"checked"`
+	Depends    []string `json:"depends"`
+	// File that tells the component is on an SD card, for updates
+	Detect string `json:"detect"`
+	// Files an update never replaces, a trailing "/" means a whole folder
+	Configs []string `json:"configs"`
+}
+
+type Manifest struct {
+	Components []ManifestComponent `json:"components"`
+}
+
+/**
+ * Flag names that can't be used as component ids
+ */
+var reserved_ids = []string{"headless", "outdir", "workdir", "retries", "lockfile", "version", "order", "conflicts", "update", "detect", "local-sps", "sps-source", "sps-check", "ban-profile", "exosphere", "boot-entry", "autoboot"}
+
+/**
+ * Turns a manifest entry into a registry component
+ * @return *component, error
+ */
+func (mc *ManifestComponent) toComponent() (*component, error) {
+	if mc.Id == "" {
+		return nil, errors.New("missing id")
+	}
+	for _, id := range reserved_ids {
+		if mc.Id == id {
+			return nil, fmt.Errorf("%s: id is reserved", mc.Id)
+		}
+	}
+	if getComponent(mc.Id) != nil {
+		return nil, fmt.Errorf("%s: id already used", mc.Id)
+	}
+
+	c := &component{
+		id:          mc.Id,
+		name:        mc.Label,
+		label:       mc.Label,
+		checked:     mc.Checked,
+		repo:        mc.Repo,
+		api_url:     mc.ApiUrl,
+		url:         mc.Url,
+		filter:      mc.Filter,
+		skip_prefix: mc.SkipPrefix,
+		dest:        filepath.FromSlash(mc.Dest),
+		dest_name:   mc.DestName,
+		depends:     mc.Depends,
+		detect:      mc.Detect,
+		configs:     mc.Configs,
+	}
+	if c.label == "" {
+		c.name, c.label = mc.Id, mc.Id
+	}
+
+	switch {
+	case mc.Url != "":
+		c.source = sourceRaw
+	case mc.Repo != "" && mc.ApiUrl != "":
+		c.source = sourceGitea
+	case mc.Repo != "":
+		c.source = sourceGitHub
+	default:
+		return nil, fmt.Errorf("%s: needs either repo or url", mc.Id)
+	}
+
+	if c.source != sourceRaw {
+		if mc.Filter == "" {
+			return nil, fmt.Errorf("%s: missing asset filter", mc.Id)
+		}
+		if _, err := regexp.Compile(mc.Filter); err != nil {
+			return nil, fmt.Errorf("%s: bad asset filter: %s", mc.Id, err)
+		}
+	}
+
+	switch mc.Install {
+	case "extract", "":
+		c.install = installExtract
+	case "move":
+		c.install = installMove
+	default:
+		return nil, fmt.Errorf("%s: unknown install action %q", mc.Id, mc.Install)
+	}
+
+	// Everything must end up inside the output dir, "a/../../x" only shows once cleaned
+	c.dest = filepath.Clean(c.dest)
+	if c.dest == "." {
+		c.dest = ""
+	}
+	if c.dest != "" && !filepath.IsLocal(c.dest) {
+		return nil, fmt.Errorf("%s: dest must be relative to the SD root", mc.Id)
+	}
+
+	// A file name, not a path
+	if c.dest_name != "" {
+		if strings.ContainsAny(c.dest_name, `/\`) {
+			return nil, fmt.Errorf("%s: dest_name must be a file name, not a path", mc.Id)
+		}
+		if problem := fat32NameProblem(c.dest_name); problem != "" {
+			return nil, fmt.Errorf("%s: bad dest_name: %s", mc.Id, problem)
+		}
+	}
+
+	for _, name := range append([]string{c.detect}, c.configs...) {
+		if name != "" && !filepath.IsLocal(filepath.FromSlash(strings.TrimSuffix(name, "/"))) {
+			return nil, fmt.Errorf("%s: %s must be relative to the SD root", mc.Id, name)
+		}
+	}
+
+	for _, id := range c.depends {
+		if getComponent(id) == nil {
+			return nil, fmt.Errorf("%s: depends on unknown component %s", mc.Id, id)
+		}
+	}
+
+	return c, nil
+}
+
+/**
+ * Reads a manifest file and adds its components to the registry
+ * @param  string file_path
+ * @return error
+ */
+func loadManifest(file_path string) error {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var manifest Manifest
+
+	if err = jsoniter.NewDecoder(file).Decode(&manifest); err != nil {
+		return fmt.Errorf("%s: %s", file_path, err)
+	}
+
+	for _, mc := range manifest.Components {
+		c, err := mc.toComponent()
+		if err != nil {
+			return fmt.Errorf("%s: %s", file_path, err)
+		}
+		components = append(components, c)
+	}
+
+	return nil
+}
+
+/**
+ * Loads the manifests found next to the executable and in the config dir, if any
+ * @return error
+ */
+func loadManifests() error {
+	var dirs []string
+
+	if dir, err := exeDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	if dir, err := configDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+
+	for i, dir := range dirs {
+		// Running from the config dir
+		if i > 0 && dir == dirs[0] {
+			continue
+		}
+
+		file_path := filepath.Join(dir, manifest_name)
+		if _, err := os.Stat(file_path); err != nil {
+			continue
+		}
+		if err := loadManifest(file_path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
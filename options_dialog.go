@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+/**
+ * Shows a dialog to choose what to do with existing files and which release is used for each
+ * component, choices are saved right away
+ * @param *Settings   settings
+ * @param fyne.Window w
+ */
+func showOptionsDialog(settings *Settings, w fyne.Window) {
+	form := widget.NewForm()
+
+	conflict_labels := []string{}
+	for _, policy := range conflict_policies {
+		conflict_labels = append(conflict_labels, conflictLabel(policy))
+	}
+
+	conflict_sel := widget.NewSelect(conflict_labels, nil)
+	conflict_sel.Selected = conflictLabel(settings.conflicts())
+	conflict_sel.OnChanged = func(label string) {
+		for _, policy := range conflict_policies {
+			if conflictLabel(policy) == label {
+				settings.Conflicts = policy
+			}
+		}
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}
+
+	form.Append("Existing files", conflict_sel)
+	form.Append("SPs sources", widget.NewButton("Edit…", func() {
+		showSPsSourcesDialog(settings, w)
+	}))
+	form.Append("Own SPs", newLocalSPsRow(settings, w))
+	form.Append("Outdated SPs", newSPsCheckSelect(settings, w))
+	form.Append("Ban prevention", widget.NewButton("Profiles…", func() {
+		showBanProfilesDialog(settings, w)
+	}))
+	form.Append("Exosphère", widget.NewButton("Edit…", func() {
+		showExosphereDialog(settings, w)
+	}))
+	form.Append("Boot entries", widget.NewButton("Edit…", func() {
+		showBootDialog(settings, w)
+	}))
+
+	for _, c := range components {
+		if c.source != sourceGitHub && c.source != sourceGitea {
+			continue
+		}
+
+		current := settings.version(c.id)
+		options := []string{versionLabel(version_latest), versionLabel(version_prerelease)}
+		if current != version_latest && current != version_prerelease {
+			options = append(options, current)
+		}
+
+		// How the latest release is chosen, pinned tags don't need it
+		order_sel := widget.NewSelect([]string{orderLabel(order_date), orderLabel(order_semver)}, nil)
+		order_sel.Selected = orderLabel(settings.order(c.id))
+		order_sel.OnChanged = func(label string) {
+			settings.Orders[c.id] = order_date
+			if label == orderLabel(order_semver) {
+				settings.Orders[c.id] = order_semver
+			}
+			if err := settings.save(); err != nil {
+				dialog.ShowError(err, w)
+			}
+		}
+		if current != version_latest && current != version_prerelease {
+			order_sel.Disable()
+		}
+
+		// Callbacks are set afterwards to avoid saving the initial values
+		sel := widget.NewSelect(options, nil)
+		sel.Selected = versionLabel(current)
+		sel.OnChanged = func(label string) {
+			version := label
+			switch label {
+			case versionLabel(version_latest):
+				version = version_latest
+			case versionLabel(version_prerelease):
+				version = version_prerelease
+			}
+
+			if version == version_latest || version == version_prerelease {
+				order_sel.Enable()
+			} else {
+				order_sel.Disable()
+			}
+
+			settings.Versions[c.id] = version
+			if err := settings.save(); err != nil {
+				dialog.ShowError(err, w)
+			}
+		}
+
+		form.Append(c.label, container.NewBorder(nil, nil, nil, order_sel, sel))
+
+		// Add recent tags in the background, the special ones are enough if this fails
+		go func() {
+			releases, err := listReleases(context.Background(), c.repo, 1, c.api_url)
+			if err != nil {
+				return
+			}
+
+			tags := []string{options[0], options[1]}
+			for _, release := range releases {
+				if !release.Draft {
+					tags = append(tags, release.TagName)
+				}
+			}
+			// Keep a pinned tag that's not among the recent ones
+			if len(options) > 2 && !slices.Contains(tags, current) {
+				tags = append(tags, current)
+			}
+
+			sel.Options = tags
+			sel.Refresh()
+		}()
+	}
+
+	dialog.ShowCustom("Options", "Close", form, w)
+}
+
+/**
+ * Shows a dialog to set where the SPs are looked for, one source per line, saved when confirmed
+ * @param *Settings   settings
+ * @param fyne.Window w
+ */
+func showSPsSourcesDialog(settings *Settings, w fyne.Window) {
+	entry := widget.NewMultiLineEntry()
+	entry.SetText(strings.Join(settings.spsSources(), "\n"))
+	entry.SetMinRowsVisible(4)
+
+	help := widget.NewLabel("One per line, tried in order:\nforum, github:{author}/{repo} or the URL of a zip file")
+
+	dialog.ShowCustomConfirm("SPs sources", "Save", "Cancel", container.NewBorder(help, nil, nil, nil, entry), func(save bool) {
+		if !save {
+			return
+		}
+
+		sources := []string{}
+		for _, line := range strings.Split(entry.Text, "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if err := checkSPsSource(line); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			sources = append(sources, line)
+		}
+
+		settings.SPsSources = sources
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+}
+
+/**
+ * Makes the row to choose a zip file or folder with the user's own SPs
+ * @param  *Settings   settings
+ * @param  fyne.Window w
+ * @return fyne.CanvasObject
+ */
+func newLocalSPsRow(settings *Settings, w fyne.Window) fyne.CanvasObject {
+	label := widget.NewLabel("")
+	label.Truncation = fyne.TextTruncateEllipsis
+
+	var clear_btn *widget.Button
+
+	// Checked before saving, a bad choice only shows up in the middle of a build otherwise
+	use := func(local_path string) {
+		if local_path != "" {
+			if _, err := checkLocalSPs(local_path); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+		}
+
+		settings.LocalSPs = local_path
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+		}
+
+		if local_path == "" {
+			label.SetText("Download them")
+			clear_btn.Disable()
+		} else {
+			label.SetText(local_path)
+			clear_btn.Enable()
+		}
+	}
+
+	zip_btn := widget.NewButton("Zip…", func() {
+		d := dialog.NewFileOpen(func(file fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if file == nil {
+				return
+			}
+			file.Close()
+			use(file.URI().Path())
+		}, w)
+		d.SetFilter(storage.NewExtensionFileFilter([]string{".zip"}))
+		d.Show()
+	})
+
+	folder_btn := widget.NewButton("Folder…", func() {
+		dialog.ShowFolderOpen(func(list fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if list != nil {
+				use(list.Path())
+			}
+		}, w)
+	})
+
+	clear_btn = widget.NewButton("Clear", func() {
+		use("")
+	})
+
+	label.SetText("Download them")
+	clear_btn.Disable()
+	if settings.LocalSPs != "" {
+		label.SetText(settings.LocalSPs)
+		clear_btn.Enable()
+	}
+
+	return container.NewBorder(nil, nil, nil, container.NewHBox(zip_btn, folder_btn, clear_btn), label)
+}
+
+/**
+ * Makes the select for what to do when the SPs look older than Atmosphère, saved right away
+ * @param  *Settings   settings
+ * @param  fyne.Window w
+ * @return *widget.Select
+ */
+func newSPsCheckSelect(settings *Settings, w fyne.Window) *widget.Select {
+	labels := []string{}
+	for _, policy := range sps_check_policies {
+		labels = append(labels, spsCheckLabel(policy))
+	}
+
+	sel := widget.NewSelect(labels, nil)
+	sel.Selected = spsCheckLabel(settings.spsCheck())
+	sel.OnChanged = func(label string) {
+		for _, policy := range sps_check_policies {
+			if spsCheckLabel(policy) == label {
+				settings.SPsCheck = policy
+			}
+		}
+		if err := settings.save(); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}
+
+	return sel
+}
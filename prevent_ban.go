@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bytes"
-	"compress/flate"
 	"os"
 	"path/filepath"
 )
 
+/**
+ * exosphere.ini of the built-in default ban prevention profile, deflated
+ */
 var compressed_exo = []byte{
 	0x64, 0x8B, 0xC1, 0x6A, 0xC6, 0x20, 0x10, 0x84,
 	0xEF, 0x3E, 0x8D, 0x16, 0x7A, 0xF4, 0x49, 0x4A,
@@ -28,6 +29,9 @@ var compressed_exo = []byte{
 	0x92, 0xB7, 0x7F, 0x01, 0x00, 0x00, 0xFF, 0xFF,
 }
 
+/**
+ * atmosphere/hosts/default.txt of the built-in default ban prevention profile, deflated
+ */
 var compressed_hosts = []byte{
 	0x7C, 0x93, 0xC1, 0x4E, 0xC3, 0x30, 0x10, 0x44,
 	0xEF, 0xF9, 0x0A, 0x4B, 0x88, 0x4B, 0xA5, 0xAE,
@@ -63,36 +67,39 @@ var compressed_hosts = []byte{
 	0xFC, 0x06, 0x00, 0x00, 0xFF, 0xFF,
 }
 
-func preventBan(outdir string) error {
-	// Create the exosphère ini file
-	exo, err := os.Create(filepath.Join(outdir, "exosphere.ini"))
+/**
+ * Writes the files of the chosen ban prevention profile, hosts files already there are merged
+ * @param  string outdir
+ * @return []string What was changed in existing hosts files, error
+ */
+func (b *Builder) preventBan(outdir string) ([]string, error) {
+	p, err := findBanProfile(b.settings.banProfile())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer exo.Close()
 
-	exo_r := flate.NewReader(bytes.NewReader(compressed_exo))
-	defer exo_r.Close()
-	_, err = exo.ReadFrom(exo_r)
-	if err != nil {
-		return err
+	// Create the exosphère ini file
+	if err = b.writeExosphere(outdir, p.Exosphere); err != nil {
+		return nil, err
 	}
 
-	// Create the hosts file
+	// Create the hosts files
 	hosts_path := filepath.Join(outdir, "atmosphere", "hosts")
-	os.MkdirAll(hosts_path, os.ModePerm)
-	hosts, err := os.Create(filepath.Join(hosts_path, "default.txt"))
-	if err != nil {
-		return err
-	}
-	defer hosts.Close()
+	report := []string{}
 
-	hosts_r := flate.NewReader(bytes.NewReader(compressed_hosts))
-	defer hosts_r.Close()
-	_, err = hosts.ReadFrom(hosts_r)
-	if err != nil {
-		return err
+	for _, hosts_name := range hosts_files {
+		hosts, ok := p.Hosts[hosts_name]
+		if !ok {
+			continue
+		}
+
+		os.MkdirAll(hosts_path, os.ModePerm)
+		changes, err := b.writeHosts(filepath.Join(hosts_path, hosts_name), "atmosphere/hosts/"+hosts_name, hosts)
+		if err != nil {
+			return report, err
+		}
+		report = append(report, changes...)
 	}
 
-	return nil
+	return report, nil
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+/**
+ * Progress bar of a download that's going on
+ */
+type download_bar struct {
+	bar  *widget.ProgressBar
+	file string
+	// Bytes and time when the download was first seen, for speed and ETA
+	first_received int64
+	started        time.Time
+	// Last values that came in
+	received int64
+	total    int64
+}
+
+/**
+ * Build progress shown above the log, a bar for the whole build and one per active download
+ */
+type progressPanel struct {
+	mutex     sync.Mutex
+	overall   *widget.ProgressBar
+	downloads *fyne.Container
+	bars      map[string]*download_bar
+	// What goes into the log container
+	container *fyne.Container
+}
+
+/**
+ * @return *progressPanel
+ */
+func newProgressPanel() *progressPanel {
+	p := &progressPanel{
+		overall:   widget.NewProgressBar(),
+		downloads: container.NewVBox(),
+		bars:      map[string]*download_bar{},
+	}
+
+	p.overall.TextFormatter = func() string {
+		return fmt.Sprintf("Overall: %.0f%%", p.overall.Value*100)
+	}
+
+	p.container = container.NewVBox(p.overall, p.downloads)
+
+	return p
+}
+
+/**
+ * Empties the panel before a build starts
+ */
+func (p *progressPanel) reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.bars = map[string]*download_bar{}
+	p.downloads.RemoveAll()
+	p.overall.SetValue(0)
+}
+
+func (p *progressPanel) Emit(e Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := e.Component + "/" + e.File
+
+	switch e.Kind {
+	case EventDownload:
+		if !e.Done {
+			d := &download_bar{bar: widget.NewProgressBar(), file: e.File, started: time.Now()}
+			d.bar.TextFormatter = d.text
+			p.bars[key] = d
+			p.downloads.Add(d.bar)
+		} else if d := p.bars[key]; d != nil {
+			delete(p.bars, key)
+			p.downloads.Remove(d.bar)
+		}
+
+	case EventProgress:
+		d := p.bars[key]
+		if d == nil {
+			return
+		}
+		if d.received == 0 {
+			// Resumed downloads start with what was already there
+			d.first_received, d.started = e.Received, time.Now()
+		}
+		d.received, d.total = e.Received, e.Total
+
+		if d.total > 0 {
+			d.bar.Max = float64(d.total)
+			d.bar.SetValue(float64(d.received))
+		} else {
+			d.bar.Refresh()
+		}
+
+	case EventBuildProgress:
+		if e.Total > 0 {
+			p.overall.Max = float64(e.Total)
+			p.overall.SetValue(float64(e.Received))
+		}
+
+	case EventFinished:
+		// Failed downloads may still be there
+		p.bars = map[string]*download_bar{}
+		p.downloads.RemoveAll()
+	}
+}
+
+/**
+ * @return string File name, bytes received, speed and time left
+ */
+func (d *download_bar) text() string {
+	txt := d.file + ": " + formatBytes(d.received)
+	if d.total > 0 {
+		txt += " of " + formatBytes(d.total)
+	}
+
+	elapsed := time.Since(d.started).Seconds()
+	if elapsed < 1 || d.received <= d.first_received {
+		return txt
+	}
+
+	speed := float64(d.received-d.first_received) / elapsed
+	txt += ", " + formatBytes(int64(speed)) + "/s"
+
+	if d.total > d.received {
+		left := time.Duration(float64(d.total-d.received)/speed) * time.Second
+		txt += ", " + left.Round(time.Second).String() + " left"
+	}
+
+	return txt
+}
+
+/**
+ * @param  int64  n Size in bytes
+ * @return string Like "1.5 MB"
+ */
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+/**
+ * How a component ended up in a build
+ */
+type ResultStatus int
+
+const (
+	// Not done yet, only while the build runs
+	ResultPending ResultStatus = iota
+	// Downloaded and installed
+	ResultOK
+	// Not installed, Err tells why
+	ResultSkipped
+	// Download or install failed, Err is set
+	ResultFailed
+)
+
+func (s ResultStatus) String() string {
+	switch s {
+	case ResultOK:
+		return "ok"
+	case ResultSkipped:
+		return "skipped"
+	case ResultFailed:
+		return "failed"
+	}
+	return "pending"
+}
+
+/**
+ * What happened to a selected component
+ */
+type ComponentResult struct {
+	Component string
+	Status    ResultStatus
+	// Release installed, if it's from one
+	Tag string
+	Err error
+}
+
+/**
+ * Left on the components the build never got to
+ */
+var errNotReached = errors.New("the build stopped before it")
+
+/**
+ * Makes a pending result for each selected component, those missing something they depend
+ * on are skipped right away
+ * @param dos_type dos
+ */
+func (b *Builder) startResults(dos dos_type) {
+	b.results = nil
+
+	for _, c := range components {
+		if !dos[c.id] {
+			continue
+		}
+
+		r := &ComponentResult{Component: c.name}
+		if !dos.wants(c) {
+			missing := []string{}
+			for _, id := range c.depends {
+				if dep := getComponent(id); dep == nil {
+					missing = append(missing, id)
+				} else if !dos.wants(dep) {
+					missing = append(missing, dep.name)
+				}
+			}
+			r.Status, r.Err = ResultSkipped, fmt.Errorf("needs %s", strings.Join(missing, ", "))
+		}
+
+		b.results = append(b.results, r)
+	}
+}
+
+/**
+ * Sets how a component ended up
+ * @param *component   c
+ * @param ResultStatus status
+ * @param string       tag    Release installed, if any
+ * @param error        err    Why it wasn't installed
+ */
+func (b *Builder) setResult(c *component, status ResultStatus, tag string, err error) {
+	for _, r := range b.results {
+		if r.Component == c.name {
+			r.Status, r.Tag, r.Err = status, tag, err
+			return
+		}
+	}
+}
+
+/**
+ * Skips whatever is still pending once the build is over
+ * @param error err Why the build was aborted, if it was
+ */
+func (b *Builder) endResults(err error) {
+	cause := errNotReached
+	if errors.Is(err, errCanceled) {
+		cause = errCanceled
+	}
+
+	for _, r := range b.results {
+		if r.Status == ResultPending {
+			r.Status, r.Err = ResultSkipped, cause
+		}
+	}
+}
+
+/**
+ * Lays out the results as a table for the log
+ * @param  []*ComponentResult results
+ * @return string
+ */
+func formatResults(results []*ComponentResult) string {
+	var sb strings.Builder
+
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Component\tResult\tDetails")
+	for _, r := range results {
+		details := r.Tag
+		if r.Err != nil {
+			details = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", r.Component, r.Status, details)
+	}
+	tw.Flush()
+
+	return sb.String()
+}
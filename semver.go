@@ -0,0 +1,97 @@
+package main
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Release tag parsed as a semantic version
+ */
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+/**
+ * Parses tags like "1.7.1", "v6.1.1" or "v2.0.0-rc.1", missing minor or patch numbers are taken as 0
+ * @param  string tag
+ * @return semver, bool False if it doesn't look like a version
+ */
+func parseSemver(tag string) (semver, bool) {
+	var v semver
+
+	tag = strings.TrimPrefix(strings.TrimPrefix(tag, "v"), "V")
+
+	// Build metadata doesn't count
+	tag, _, _ = strings.Cut(tag, "+")
+
+	core, prerelease, found := strings.Cut(tag, "-")
+	if found {
+		if prerelease == "" {
+			return v, false
+		}
+		v.prerelease = strings.Split(prerelease, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.numbers[i] = n
+	}
+
+	return v, true
+}
+
+/**
+ * Compares two versions following semver precedence rules
+ * @param  semver other
+ * @return int    -1 if v is older, 1 if newer, 0 if same
+ */
+func (v semver) compare(other semver) int {
+	for i := range v.numbers {
+		if v.numbers[i] != other.numbers[i] {
+			return cmp.Compare(v.numbers[i], other.numbers[i])
+		}
+	}
+
+	// A normal version is newer than its prereleases
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+
+		// Numeric identifiers are lower than alphanumeric ones
+		a_n, a_err := strconv.Atoi(a)
+		b_n, b_err := strconv.Atoi(b)
+		switch {
+		case a_err == nil && b_err == nil:
+			return cmp.Compare(a_n, b_n)
+		case a_err == nil:
+			return -1
+		case b_err == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+
+	return cmp.Compare(len(v.prerelease), len(other.prerelease))
+}
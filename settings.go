@@ -0,0 +1,229 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+/**
+ * File name of the saved settings, inside the config dir
+ */
+const settings_name string = "settings.json"
+
+/**
+ * Special version values, anything else is taken as an exact release tag
+ */
+const (
+	version_latest     string = "latest"
+	version_prerelease string = "prerelease"
+)
+
+/**
+ * How the latest release is chosen
+ */
+const (
+	order_date   string = "date"
+	order_semver string = "semver"
+)
+
+/**
+ * Describes a version setting for the log and the GUI
+ * @param  string version
+ * @return string
+ */
+func versionLabel(version string) string {
+	switch version {
+	case version_latest, "":
+		return "Latest stable"
+	case version_prerelease:
+		return "Latest including prereleases"
+	}
+	return version
+}
+
+/**
+ * Describes an order setting for the log and the GUI
+ * @param  string order
+ * @return string
+ */
+func orderLabel(order string) string {
+	if order == order_semver {
+		return "semantic version"
+	}
+	return "publish date"
+}
+
+/**
+ * Choices kept between runs
+ */
+type Settings struct {
+	// Release to use for each component id, latest stable if not set
+	Versions map[string]string `json:"versions"`
+	// How the latest release is chosen for each component id, by publish date if not set
+	Orders map[string]string `json:"orders"`
+	// How many times a failed download is retried, default_retries if not set
+	Retries *int `json:"retries,omitempty"`
+	// What to do with files already in the output dir, conflict_overwrite if not set
+	Conflicts string `json:"conflicts,omitempty"`
+	// Zip file or folder used as the SPs instead of downloading them
+	LocalSPs string `json:"local_sps,omitempty"`
+	// Where the SPs are looked for, in order, default_sps_sources if not set
+	SPsSources []string `json:"sps_sources,omitempty"`
+	// What to do when the SPs look older than Atmosphère, sps_check_warn if not set
+	SPsCheck string `json:"sps_check,omitempty"`
+	// Ban prevention files written with Atmosphère, default_ban_profile if not set
+	BanProfile string `json:"ban_profile,omitempty"`
+	// exosphere.ini options set on top of the ban prevention profile
+	Exosphere *ExosphereConfig `json:"exosphere,omitempty"`
+	// Boot entries written into hekate_ipl.ini, left alone if not set
+	Boot *BootConfig `json:"boot,omitempty"`
+	// Set from the command line
+	retries_override *int
+}
+
+/**
+ * Download retries when not set
+ */
+const default_retries int = 3
+
+/**
+ * Reads the saved settings, missing file means defaults
+ * @return *Settings, error Defaults are returned along with any error
+ */
+func loadSettings() (*Settings, error) {
+	settings := &Settings{
+		Versions: map[string]string{},
+		Orders:   map[string]string{},
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return settings, err
+	}
+
+	file, err := os.Open(filepath.Join(dir, settings_name))
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	defer file.Close()
+
+	if err = jsoniter.NewDecoder(file).Decode(settings); err != nil {
+		return settings, err
+	}
+
+	if settings.Versions == nil {
+		settings.Versions = map[string]string{}
+	}
+	if settings.Orders == nil {
+		settings.Orders = map[string]string{}
+	}
+
+	return settings, nil
+}
+
+/**
+ * Writes the settings into the config dir
+ * @return error
+ */
+func (s *Settings) save() error {
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(dir, os.ModePerm)
+
+	data, err := jsoniter.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, settings_name), data, 0644)
+}
+
+/**
+ * Gets the release to use for a component
+ * @param  string id Component id
+ * @return string
+ */
+func (s *Settings) version(id string) string {
+	if version, ok := s.Versions[id]; ok && version != "" {
+		return version
+	}
+	return version_latest
+}
+
+/**
+ * Gets how the latest release is chosen for a component
+ * @param  string id Component id
+ * @return string
+ */
+func (s *Settings) order(id string) string {
+	if s.Orders[id] == order_semver {
+		return order_semver
+	}
+	return order_date
+}
+
+/**
+ * Gets how many times a failed download is retried
+ * @return int
+ */
+func (s *Settings) retries() int {
+	if s.retries_override != nil {
+		return *s.retries_override
+	}
+	if s.Retries == nil || *s.Retries < 0 {
+		return default_retries
+	}
+	return *s.Retries
+}
+
+/**
+ * Gets what to do with files already in the output dir
+ * @return string
+ */
+func (s *Settings) conflicts() string {
+	switch s.Conflicts {
+	case conflict_skip, conflict_newer, conflict_backup:
+		return s.Conflicts
+	}
+	return conflict_overwrite
+}
+
+/**
+ * Gets the name of the ban prevention profile to use
+ * @return string
+ */
+func (s *Settings) banProfile() string {
+	if s.BanProfile == "" {
+		return default_ban_profile
+	}
+	return s.BanProfile
+}
+
+/**
+ * Gets where the SPs are looked for, in order
+ * @return []string
+ */
+func (s *Settings) spsSources() []string {
+	if len(s.SPsSources) == 0 {
+		return default_sps_sources
+	}
+	return s.SPsSources
+}
+
+/**
+ * Gets what to do when the SPs look older than Atmosphère
+ * @return string
+ */
+func (s *Settings) spsCheck() string {
+	switch s.SPsCheck {
+	case sps_check_block, sps_check_off:
+		return s.SPsCheck
+	}
+	return sps_check_warn
+}
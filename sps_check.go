@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/**
+ * What to do when the SPs look older than the Atmosphère they're installed with
+ */
+const (
+	sps_check_warn  string = "warn"
+	sps_check_block string = "block"
+	sps_check_off   string = "off"
+)
+
+var sps_check_policies = []string{sps_check_warn, sps_check_block, sps_check_off}
+
+/**
+ * Describes an SPs check policy for the log and the GUI
+ * @param  string policy
+ * @return string
+ */
+func spsCheckLabel(policy string) string {
+	switch policy {
+	case sps_check_block:
+		return "Don't build"
+	case sps_check_off:
+		return "Don't check"
+	}
+	return "Warn"
+}
+
+var version_re = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+/**
+ * Firmware versions in the comments of patches.ini, like "#FS 19.0.0-exfat"
+ */
+var patches_ini_fs_re = regexp.MustCompile(`(?i)^#\s*FS\s+v?(\d+\.\d+\.\d+)`)
+
+/**
+ * Words right before a version in an SPs file name. After the generic ones it can be either
+ */
+var (
+	sps_ams_words      = []string{"ams", "atmosphere", "atmosphère"}
+	sps_firmware_words = []string{"cfw", "fw", "firmware", "hos"}
+	sps_generic_words  = []string{"sigpatches", "sps", "package3"}
+)
+
+/**
+ * Firmware versions went past 10 long ago while Atmosphère is still on 1.x
+ */
+const sps_min_firmware_major int = 10
+
+/**
+ * What can be told about the SPs from their files
+ */
+type sps_info struct {
+	// Atmosphère version they're made for, empty if the file name doesn't say
+	ams string
+	// Highest firmware from the file name or patches.ini, empty if unknown
+	firmware string
+	// Newest file in the zip
+	date time.Time
+}
+
+/**
+ * Reads the Atmosphère and firmware versions from an SPs file name, like
+ * "Hekate+AMS-package3-sigpatches-1.7.1-cfw-19.0.0_V0.zip"
+ * @param  string name
+ * @return string Atmosphère version, empty if not found
+ * @return string Firmware version, empty if not found
+ */
+func parseSPsFileName(name string) (string, string) {
+	ams, firmware := "", ""
+
+	for _, loc := range version_re.FindAllStringIndex(name, -1) {
+		before := strings.TrimRight(strings.ToLower(name[:loc[0]]), "-_+. v")
+		version := name[loc[0]:loc[1]]
+
+		is_ams, is_firmware := false, false
+		for _, word := range sps_ams_words {
+			is_ams = is_ams || strings.HasSuffix(before, word)
+		}
+		for _, word := range sps_firmware_words {
+			is_firmware = is_firmware || strings.HasSuffix(before, word)
+		}
+		for _, word := range sps_generic_words {
+			if strings.HasSuffix(before, word) {
+				v, _ := parseSemver(version)
+				is_firmware = v.numbers[0] >= sps_min_firmware_major
+				is_ams = !is_firmware
+			}
+		}
+
+		switch {
+		case is_firmware && firmware == "":
+			firmware = version
+		case is_ams && ams == "":
+			ams = version
+		}
+	}
+
+	return ams, firmware
+}
+
+/**
+ * Finds the highest firmware patches.ini has patches for
+ * @param  io.Reader r Contents of patches.ini
+ * @return string Empty if there are none
+ */
+func patchesIniFirmware(r io.Reader) string {
+	highest := ""
+	var highest_v semver
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := patches_ini_fs_re.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		if v, ok := parseSemver(m[1]); ok && (highest == "" || v.compare(highest_v) > 0) {
+			highest, highest_v = m[1], v
+		}
+	}
+
+	return highest
+}
+
+/**
+ * Reads what the SPs are made for from their zip
+ * @param  *Asset asset
+ * @return sps_info, error
+ */
+func readSPsInfo(asset *Asset) (sps_info, error) {
+	var info sps_info
+	info.ams, info.firmware = parseSPsFileName(asset.File)
+
+	r, err := zip.OpenReader(asset.path())
+	if err != nil {
+		return info, err
+	}
+	defer r.Close()
+
+	for _, file := range r.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if modified := file.Modified; modified.After(info.date) {
+			info.date = modified
+		}
+
+		if file.Name != "bootloader/patches.ini" {
+			continue
+		}
+		// The file name may be older than the patches in it
+		found := ""
+		if ini, err := file.Open(); err == nil {
+			found = patchesIniFirmware(ini)
+			ini.Close()
+		}
+		fs, fs_ok := parseSemver(found)
+		if firmware, ok := parseSemver(info.firmware); fs_ok && (!ok || fs.compare(firmware) > 0) {
+			info.firmware = found
+		}
+	}
+
+	return info, nil
+}
+
+/**
+ * Tells why the SPs look older than an Atmosphère release
+ * @param  sps_info   info
+ * @param  string     tag       Atmosphère release tag
+ * @param  *time.Time published Atmosphère release date, nil if unknown
+ * @return string     Empty if they don't, or if it can't be told
+ */
+func spsOutdatedReason(info sps_info, tag string, published *time.Time) string {
+	ams, ams_ok := parseSemver(tag)
+	made_for, made_for_ok := parseSemver(info.ams)
+
+	switch {
+	case ams_ok && made_for_ok:
+		if made_for.compare(ams) < 0 {
+			return fmt.Sprintf("they're made for Atmosphère %s", info.ams)
+		}
+	case published != nil && !info.date.IsZero():
+		// A day of leeway for time zones and zips made right before the release
+		if info.date.Add(24 * time.Hour).Before(*published) {
+			return fmt.Sprintf("their newest file is from %s and Atmosphère %s was released on %s", info.date.Format(time.DateOnly), tag, published.Format(time.DateOnly))
+		}
+	}
+
+	return ""
+}
+
+/**
+ * Compares the SPs with the Atmosphère they're going to be installed with
+ * @param  *Lockfile lock Downloaded components
+ * @return error     Only if the build must stop
+ */
+func (b *Builder) checkSPs(lock *Lockfile) error {
+	policy := b.settings.spsCheck()
+	ams, sps := lock.component("atmosphere"), lock.component("sps")
+
+	if policy == sps_check_off || ams == nil || sps == nil || ams.Tag == "" {
+		return nil
+	}
+
+	for _, asset := range sps.Assets {
+		info, err := readSPsInfo(asset)
+		if err != nil {
+			continue
+		}
+
+		made_for := []string{}
+		if info.ams != "" {
+			made_for = append(made_for, "Atmosphère "+info.ams)
+		}
+		if info.firmware != "" {
+			made_for = append(made_for, "firmware up to "+info.firmware)
+		}
+		if len(made_for) > 0 {
+			b.info("SPs", "%s is made for %s", asset.File, strings.Join(made_for, ", "))
+		}
+
+		reason := spsOutdatedReason(info, ams.Tag, ams.Published)
+		if reason == "" {
+			return nil
+		}
+
+		if policy == sps_check_block {
+			return fmt.Errorf("the SPs look older than Atmosphère %s, %s. Get newer ones or change the outdated SPs option to build anyway", ams.Tag, reason)
+		}
+		b.warn("SPs", "They look older than Atmosphère %s, %s. They may not work until newer ones are out", ams.Tag, reason)
+		return nil
+	}
+
+	return nil
+}
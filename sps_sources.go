@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Places the SPs can come from: the forum thread, the releases of a GitHub repo written as
+ * github:{author}/{repo}, or a plain URL to a zip file
+ */
+const (
+	sps_source_forum  string = "forum"
+	sps_source_github string = "github:"
+)
+
+/**
+ * Sources tried when none are set
+ */
+var default_sps_sources = []string{sps_source_forum}
+
+var github_repo_re = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+/**
+ * @param  string source
+ * @return error  Why it can't be used
+ */
+func checkSPsSource(source string) error {
+	switch {
+	case source == sps_source_forum:
+		return nil
+	case strings.HasPrefix(source, sps_source_github):
+		if !github_repo_re.MatchString(strings.TrimPrefix(source, sps_source_github)) {
+			return fmt.Errorf("%s must be formatted as github:{author}/{repo}", source)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s is not forum, github:{author}/{repo} or an http(s) URL", source)
+	}
+	if name := path.Base(u.Path); name == "/" || name == "." {
+		return fmt.Errorf("%s has no file name", source)
+	}
+
+	return nil
+}
+
+/**
+ * Describes an SPs source for the log
+ * @param  string source
+ * @return string
+ */
+func spsSourceLabel(source string) string {
+	switch {
+	case source == sps_source_forum:
+		return "the forum thread"
+	case strings.HasPrefix(source, sps_source_github):
+		return "the releases of " + strings.TrimPrefix(source, sps_source_github)
+	}
+	return source
+}
+
+/**
+ * Downloads the SPs from one source
+ * @param  context.Context ctx
+ * @param  string          source
+ * @return string          Release tag, empty if there are no releases
+ * @return []*Asset, error
+ */
+func (b *Builder) getSPsFrom(ctx context.Context, source string) (string, []*Asset, error) {
+	switch {
+	case source == sps_source_forum:
+		fd, err := findLatestSPs(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+
+		asset := &Asset{File: fd.sps_filename, Url: fd.download_url}
+		if err = b.getAsset(ctx, "SPs", asset); err != nil {
+			return "", nil, err
+		}
+
+		return "", []*Asset{asset}, nil
+
+	case strings.HasPrefix(source, sps_source_github):
+		release, assets, err := b.getReleaseAssets(ctx, "SPs", strings.TrimPrefix(source, sps_source_github), `(?i)\.zip$`, version_latest, order_date)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(assets) == 0 {
+			return "", nil, fmt.Errorf("release %s has no zip files", release.TagName)
+		}
+
+		return release.TagName, assets, nil
+	}
+
+	asset, err := b.getRawFile(ctx, "SPs", source)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "", []*Asset{asset}, nil
+}
+
+/**
+ * Downloads the SPs from the first source that works
+ * @param  context.Context ctx
+ * @return string          Release tag, empty if the source has no releases
+ * @return []*Asset, error
+ */
+func (b *Builder) getLatestSPs(ctx context.Context) (string, []*Asset, error) {
+	sources := b.settings.spsSources()
+
+	for _, source := range sources {
+		tag, assets, err := b.getSPsFrom(ctx, source)
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		if err != nil {
+			b.warn("SPs", "Could not get them from %s: %s", spsSourceLabel(source), err)
+			continue
+		}
+
+		var names []string
+		for _, asset := range assets {
+			names = append(names, asset.File)
+		}
+		b.info("SPs", "Got %s from %s", strings.Join(names, ", "), spsSourceLabel(source))
+
+		return tag, assets, nil
+	}
+
+	if len(sources) == 1 {
+		return "", nil, errors.New("the only SPs source failed")
+	}
+	return "", nil, fmt.Errorf("all %d SPs sources failed", len(sources))
+}
+
+/**
+ * Finds out the newest SPs from the first source that answers, without downloading them
+ * @param  context.Context ctx
+ * @param  *Settings       settings
+ * @return string          Release tag or file name, error
+ */
+func latestSPsVersion(ctx context.Context, settings *Settings) (string, error) {
+	var errs []error
+
+	for _, source := range settings.spsSources() {
+		switch {
+		case source == sps_source_forum:
+			fd, err := findLatestSPs(ctx)
+			if err == nil {
+				return fd.sps_filename, nil
+			}
+			errs = append(errs, err)
+
+		case strings.HasPrefix(source, sps_source_github):
+			release, _, err := resolveRelease(ctx, strings.TrimPrefix(source, sps_source_github), version_latest, order_date)
+			if err == nil {
+				return release.TagName, nil
+			}
+			errs = append(errs, err)
+
+		default:
+			// Same name the file is saved under
+			name, err := rawFileName(source)
+			if err == nil {
+				return name, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	return "", errors.Join(errs...)
+}
@@ -1,237 +1,573 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-
-	"fyne.io/fyne/v2/data/binding"
-	"fyne.io/fyne/v2/widget"
+	"strings"
+	"sync"
+	"time"
 )
 
-const workdir string = "workdir"
+/**
+ * How many components are downloaded at the same time
+ */
+const download_workers int = 4
+
+/**
+ * Folder where all downloaded files are kept, can be changed from the command line
+ */
+var workdir string = "workdir"
+
+/**
+ * Ids of the components that are going to be done
+ */
+type dos_type map[string]bool
 
 /**
- * Copies a file (why there's no os.Copy ???)
+ * Just in case someone starts a build with no actions selected
+ */
+var errNothingToDo = errors.New(" Nothing to do! ")
+
+var errCanceled = errors.New("canceled")
+
+/**
+ * Tells if a component is selected along with everything it depends on
+ * @param  *component c
+ * @return bool
+ */
+func (dos dos_type) wants(c *component) bool {
+	if !dos[c.id] {
+		return false
+	}
+	for _, id := range c.depends {
+		if dep := getComponent(id); dep == nil || !dos.wants(dep) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Checks the actions make sense before starting a build
+ * @return error
+ */
+func (dos dos_type) check() error {
+	nothing := true
+
+	for _, c := range components {
+		if !dos.wants(c) {
+			continue
+		}
+		nothing = false
+
+		for _, id := range c.conflicts {
+			if other := getComponent(id); other != nil && dos.wants(other) {
+				return fmt.Errorf("%s and %s can't be used together", c.label, other.label)
+			}
+		}
+	}
+
+	if nothing {
+		return errNothingToDo
+	}
+	return nil
+}
+
+/**
+ * Copies a file (why there's no os.Copy ???) following the conflict policy
  * @param  src string Source file path
  * @param  dst string Destination file path
  * @return error
  */
-func copyFile(src, dst string) error {
+func (b *Builder) copyFile(src, dst string) error {
 	src_file, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer src_file.Close()
 
-	_, err = os.Stat(dst)
-	if err == nil {
-		return errors.New("file already exists")
+	info, err := src_file.Stat()
+	if err != nil {
+		return err
 	}
 
-	dst_file, err := os.Create(dst)
-	if err != nil {
+	return b.writeFile(dst, src_file, info.ModTime())
+}
+
+/**
+ * Moves a downloaded file into the output dir following the conflict policy. It's copied and then
+ * removed since the workdir and the output dir are often on different drives, like when building
+ * right into an SD card
+ * @param  src string Source file path
+ * @param  dst string Destination file path
+ * @return error
+ */
+func (b *Builder) moveFile(src, dst string) error {
+	if err := b.copyFile(src, dst); err != nil {
 		return err
 	}
-	defer dst_file.Close()
+	os.Remove(src)
+
+	return nil
+}
+
+/**
+ * Runs the stuff, shared by the GUI and the headless mode
+ * @param  context.Context ctx    Cancels the build
+ * @param  dos_type        dos    Processes to follow
+ * @param  string          outdir Output directory
+ * @return error           Set if a required step failed or it was canceled
+ */
+func (b *Builder) Run(ctx context.Context, dos dos_type, outdir string) error {
+	return b.finish(b.run(ctx, dos, outdir, nil))
+}
+
+/**
+ * Rebuilds exactly what a lockfile says, fails if any downloaded file is different
+ * @param  context.Context ctx       Cancels the build
+ * @param  string          lock_path Lockfile of a previous build
+ * @param  string          outdir    Output directory
+ * @return error
+ */
+func (b *Builder) RunLockfile(ctx context.Context, lock_path string, outdir string) error {
+	lock, err := readLockfile(lock_path)
+	if err != nil {
+		return b.finish(fmt.Errorf("could not read lockfile: %s", err))
+	}
 
-	_, err = dst_file.ReadFrom(src_file)
+	dos := dos_type{}
+	for _, id := range lock.Selected {
+		dos[id] = true
+	}
+
+	return b.finish(b.run(ctx, dos, outdir, lock))
+}
+
+/**
+ * Tells the sink the build is over
+ * @param  error err Why the build was aborted, if it was
+ * @return error
+ */
+func (b *Builder) finish(err error) error {
+	if err != nil {
+		b.emit(Event{Kind: EventFatal, Err: err})
+	}
+	if len(b.results) > 0 {
+		b.endResults(err)
+		b.emit(Event{Kind: EventSummary, Results: b.results})
+		b.results = nil
+	}
+	b.emit(Event{Kind: EventFinished, Err: err})
 
 	return err
 }
 
 /**
- * Runs the stuff
- * @param dos_type       dos               Processes to follow
- * @param binding.String folder_entry_data Output directory folder entry data
- * @param *widget.Button close_btn         Close button in log window, will be re-enabled after process is finished
+ * @param  context.Context ctx
+ * @param  dos_type        dos
+ * @param  string          outdir
+ * @param  *Lockfile       from_lock If set, its files are used instead of the latest ones
+ * @return error
  */
-var start func(dos_type, binding.String, *widget.Button) = func(dos dos_type, folder_entry_data binding.String, close_btn *widget.Button) {
+func (b *Builder) run(ctx context.Context, dos dos_type, outdir string, from_lock *Lockfile) error {
 	// We'll use this folder for all downloaded files
 	os.MkdirAll(workdir, os.ModePerm)
 
-	// Download latest Atmosphère release
-	var atmosphere_zipfile *string = nil
+	lock := &Lockfile{Created: time.Now().UTC()}
 
-	if dos.atmosphere {
-		repo := "Atmosphere-NX/Atmosphere"
-		assets, err := getLatestAssets(repo, `\.zip$`)
-		if err != nil {
-			log_add(fmt.Sprintf("! Could not get latest %s asset: %s\n", repo, err))
-			close_btn.Enable()
-			return
+	b.conflicts = nil
+	defer b.reportConflicts(outdir)
+
+	b.startResults(dos)
+
+	// Every download, every install and the lockfile
+	steps := 1
+	for _, c := range components {
+		if dos.wants(c) {
+			steps++
+			if c.source != sourceNone {
+				steps++
+			}
 		}
-		atmosphere_zipfile = assets[0]
 	}
+	b.startSteps(steps)
 
-	// Download latest Hekate release
-	var hekate_zipfile *string = nil
-	var bootdat_zipfile *string = nil
-	var lockpick_bin *string = nil
+	// Download everything first so a failed required component doesn't leave a half-built folder
+	results := b.fetchAll(ctx, dos, from_lock)
 
-	if dos.hekate {
-		repo := "CTCaer/hekate"
-		assets, err := getLatestAssets(repo, `hekate_ctcaer.+\.zip$`)
-		if err != nil {
-			log_add(fmt.Sprintf("! Could not get latest %s asset: %s\n", repo, err))
-			close_btn.Enable()
-			return
+	if ctx.Err() != nil {
+		var missing []string
+		for i, c := range components {
+			if dos.wants(c) && c.source != sourceNone && results[i].err != nil {
+				missing = append(missing, c.name)
+			}
+		}
+		if len(missing) > 0 {
+			b.warn("", "Canceled, not downloaded: %s", strings.Join(missing, ", "))
 		}
-		hekate_zipfile = assets[0]
+		return b.canceled(dos, 0, "")
+	}
 
-		// Download SX-Gear boot.dat and config to launch Hekate
-		if dos.bootdat {
-			bootdat_zipfile, err = getBootDat()
-			if err != nil {
-				log_add(fmt.Sprintf("! Could not get SX Gear boot files: %s\n", err))
-			}
+	// Results are checked in registry order so the outcome doesn't depend on which download ended first
+	for i, c := range components {
+		if !dos.wants(c) {
+			continue
 		}
 
-		// Download latest Lockpick_RCM release
-		if dos.lockpick {
-			repo = "Mirror/Lockpick_RCM"
-			assets, err = getLatestAssets(repo, `\.bin$`, "git.gdm.rocks/api/v1")
-			if err != nil {
-				log_add(fmt.Sprintf("! Could not get latest %s asset: %s\n", repo, err))
+		if c.source == sourceNone {
+			lock.Selected = append(lock.Selected, c.id)
+			lock.Components = append(lock.Components, &LockComponent{Id: c.id, Name: c.name, Assets: []*Asset{}})
+			continue
+		}
+
+		lc, err := results[i].lc, results[i].err
+
+		if err == nil && (lc == nil || len(lc.Assets) == 0) {
+			err = errors.New("no matching files found")
+		}
+		if err != nil {
+			b.setResult(c, ResultFailed, "", err)
+			// Rebuilding must be exact
+			if c.required || from_lock != nil {
+				return fmt.Errorf("could not get %s: %s", c.name, err)
 			}
-			lockpick_bin = assets[0]
+			b.warn(c.name, "Could not get %s: %s", c.name, err)
+			// Won't be installed either
+			b.stepsAdvance()
+			continue
 		}
-	}
 
-	// Download latest SPs
-	sps_zipfile, err := getLatestSPs()
-	if err != nil {
-		log_add(fmt.Sprintf("! Could not get SPs: %s\n", err))
+		lock.Selected = append(lock.Selected, c.id)
+		lock.Components = append(lock.Components, lc)
 	}
 
-	// Download latest DBI
-	var dbi_files []*string
-	if dos.dbi {
-		repo := "rashevskyv/dbi"
-		dbi_files, err = getLatestAssets(repo, `((dbi\.config)|(DBI\.nro))$`)
-		if err != nil {
-			log_add(fmt.Sprintf("! Could not get latest %s assets: %s\n", repo, err))
+	// A rebuild is exactly what was built before, checked back then
+	if from_lock == nil {
+		if err := b.checkSPs(lock); err != nil {
+			b.setResult(getComponent("sps"), ResultFailed, "", err)
+			return err
 		}
 	}
 
-	outdir, _ := folder_entry_data.Get()
-	log_add(fmt.Sprintf("-------\nOutput directory: %s\n-------\n", outdir))
+	b.info("", "-------\nOutput directory: %s\n-------", outdir)
 
 	// If output dir doesn't exist, create it
 	os.MkdirAll(outdir, os.ModePerm)
 
-	// Extract Atmosphère
-	if dos.atmosphere {
-		log_add(fmt.Sprintf("Extracting %s… ", filepath.Base(*atmosphere_zipfile)))
-		if err = extractZip(*atmosphere_zipfile, outdir); err != nil {
-			log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", *atmosphere_zipfile, err))
-			close_btn.Enable()
-			return
+	// Components with rejected zip entries, the build fails at the end
+	var unsafe []string
+
+	for i, c := range components {
+		if !dos.wants(c) {
+			continue
 		}
-		log_add("Done\n")
 
-		// Prevent ban
-		log_add("Creating ban prevention files… ")
-		if err = preventBan(outdir); err != nil {
-			log_add(fmt.Sprintf("\n! Could not create files: %s\n", err))
-		} else {
-			log_add("Done\n")
+		if ctx.Err() != nil {
+			return b.canceled(dos, i, "")
 		}
 
-		// Extract bootlogo if found
-		boot_logo_zip := filepath.Join(workdir, "bootlogo.zip")
-		if _, err := os.Stat(boot_logo_zip); err == nil {
-			log_add("Extracting custom boot logo… ")
-			if err = extractZip(boot_logo_zip, filepath.Join(outdir, "atmosphere", "exefs_patches")); err != nil {
-				log_add(fmt.Sprintf("\n! Could not extract boot logo: %s\n", err))
+		lc := lock.component(c.id)
+		if lc == nil {
+			continue
+		}
+
+		b.written = map[string]string{}
+
+		err := b.install(ctx, c, lc, outdir)
+		var after_err error
+		if err == nil && c.after != nil {
+			after_err = c.after(ctx, b, outdir)
+		}
+
+		lc.Files = b.installedFiles(outdir)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				b.setResult(c, ResultFailed, "", errors.New("canceled, partially installed"))
+				return b.canceled(dos, i+1, c.name)
+			}
+			b.setResult(c, ResultFailed, "", err)
+			if errors.Is(err, errUnsafeEntries) {
+				unsafe = append(unsafe, c.name)
+			}
+			if c.required {
+				return fmt.Errorf("could not install %s: %s", c.name, err)
+			}
+			b.warn(c.name, "Could not install %s: %s", c.name, err)
+		} else {
+			// Extra steps only fail the build for rejected zip entries, at the end like the others
+			if after_err != nil {
+				unsafe = append(unsafe, c.name)
+				b.setResult(c, ResultFailed, lc.Tag, after_err)
 			} else {
-				log_add("Done\n")
+				b.setResult(c, ResultOK, lc.Tag, nil)
+			}
+			if b.update != nil {
+				b.removeStale(c, lc)
 			}
 		}
+
+		b.stepsAdvance()
+	}
+
+	if b.update != nil {
+		b.update.carryOver(lock)
+	}
+
+	b.step("", "Writing %s", lockfile_name)
+	if err := lock.write(outdir); err != nil {
+		b.warn("", "Could not write %s: %s", lockfile_name, err)
+	} else {
+		b.stepDone("")
+	}
+	b.stepsAdvance()
+
+	if len(unsafe) > 0 {
+		return fmt.Errorf("unsafe files were rejected from %s", strings.Join(unsafe, ", "))
+	}
+
+	return nil
+}
+
+/**
+ * Tells what a canceled build left undone, the output dir is left as it is
+ * @param  dos_type dos
+ * @param  int      from    Registry index of the first component that wasn't installed
+ * @param  string   partial Name of the component that was being installed, if any
+ * @return error
+ */
+func (b *Builder) canceled(dos dos_type, from int, partial string) error {
+	if partial != "" {
+		b.warn(partial, "Canceled, %s was partially installed", partial)
 	}
 
-	// Extract Hekate
-	if dos.hekate {
-		log_add(fmt.Sprintf("Extracting %s… ", filepath.Base(*hekate_zipfile)))
-		if err = extractZip(*hekate_zipfile, outdir, "hekate_ctcaer"); err != nil {
-			log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", *hekate_zipfile, err))
-			close_btn.Enable()
-			return
+	var left []string
+	for _, c := range components[from:] {
+		if dos.wants(c) {
+			left = append(left, c.name)
 		}
-		log_add("Done\n")
+	}
+	if len(left) > 0 {
+		b.warn("", "Canceled, not installed: %s", strings.Join(left, ", "))
+	}
 
-		// Copy hekate payload.bin to output dir
-		if dos.payload {
-			log_add("Copying Hekate payload.bin… ")
-			if err = copyFile(
-				filepath.Join(outdir, "bootloader", "update.bin"),
-				filepath.Join(outdir, "payload.bin"),
-			); err != nil {
-				log_add(fmt.Sprintf("\n! Could not create payload.bin: %s\n", err))
-			} else {
-				log_add("Done\n")
+	return errCanceled
+}
+
+/**
+ * What fetching a component ended with
+ */
+type fetch_result struct {
+	lc  *LockComponent
+	err error
+}
+
+/**
+ * Downloads every selected component at the same time, download_workers at most
+ * @param  context.Context ctx
+ * @param  dos_type        dos
+ * @param  *Lockfile       from_lock If set, its files are used instead of the latest ones
+ * @return []fetch_result  Same length and order as the components registry
+ */
+func (b *Builder) fetchAll(ctx context.Context, dos dos_type, from_lock *Lockfile) []fetch_result {
+	results := make([]fetch_result, len(components))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for range download_workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := components[i]
+				switch {
+				case ctx.Err() != nil:
+					results[i].err = ctx.Err()
+				case from_lock != nil:
+					results[i].lc, results[i].err = b.fetchLocked(ctx, c, from_lock.component(c.id))
+				default:
+					results[i].lc, results[i].err = b.fetch(ctx, c)
+				}
+				b.stepsAdvance()
 			}
-		} else if dos.bootdat && bootdat_zipfile != nil { // Extract SX Gear boot files
-			log_add("Extracting SX Gear boot files… ")
-			if err = extractZip(*bootdat_zipfile, outdir); err != nil {
-				log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", *bootdat_zipfile, err))
-			} else {
-				log_add("Done\n")
+		}()
+	}
+
+	for i, c := range components {
+		if dos.wants(c) && c.source != sourceNone {
+			jobs <- i
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	return results
+}
+
+/**
+ * Downloads the files of a component into the workdir
+ * @param  context.Context ctx
+ * @param  *component      c
+ * @return *LockComponent, error
+ */
+func (b *Builder) fetch(ctx context.Context, c *component) (*LockComponent, error) {
+	lc := &LockComponent{Id: c.id, Name: c.name}
+
+	switch c.source {
+	case sourceGitHub, sourceGitea:
+		release, assets, err := b.getReleaseAssets(ctx, c.name, c.repo, c.filter, b.settings.version(c.id), b.settings.order(c.id), c.api_url)
+		if err != nil {
+			return nil, err
+		}
+		lc.Tag, lc.Assets = release.TagName, assets
+		if !release.PublishedAt.IsZero() {
+			lc.Published = &release.PublishedAt
+		}
+	case sourceRaw:
+		asset, err := b.getRawFile(ctx, c.name, c.url)
+		if err != nil {
+			return nil, err
+		}
+		lc.Assets = []*Asset{asset}
+	case sourceForum:
+		if local := b.settings.LocalSPs; local != "" {
+			asset, err := b.getLocalSPs(local)
+			if err != nil {
+				return nil, err
 			}
+			lc.Assets = []*Asset{asset}
+			break
 		}
 
-		// Move Lockpick_RCM.bin
-		if dos.lockpick && lockpick_bin != nil {
-			log_add("Moving Lockpick_RCM to payloads… ")
-			if err = os.Rename(
-				*lockpick_bin,
-				filepath.Join(outdir, "bootloader", "payloads", "Lockpick_RCM.bin"),
-			); err != nil {
-				log_add(fmt.Sprintf("\n! Could not move Lockpick_RCM: %s\n", err))
-			} else {
-				log_add("Done\n")
+		tag, assets, err := b.getLatestSPs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		lc.Tag, lc.Assets = tag, assets
+	}
+
+	// Keep a record of exactly what is going to be used
+	for _, asset := range lc.Assets {
+		size, sha, err := hashFile(asset.path())
+		if err != nil {
+			return nil, err
+		}
+		asset.Size, asset.Sha256 = size, sha
+	}
+
+	return lc, nil
+}
+
+/**
+ * Downloads the files of a component as recorded in a lockfile
+ * @param  context.Context ctx
+ * @param  *component      c
+ * @param  *LockComponent  locked Entry in the lockfile
+ * @return *LockComponent, error
+ */
+func (b *Builder) fetchLocked(ctx context.Context, c *component, locked *LockComponent) (*LockComponent, error) {
+	if locked == nil {
+		return nil, errors.New("not in lockfile")
+	}
+
+	if locked.Tag != "" {
+		b.emit(Event{Kind: EventResolved, Component: c.name, Tag: locked.Tag, Message: "from lockfile"})
+	}
+
+	for _, asset := range locked.Assets {
+		asset.expected, asset.expected_from = asset.Sha256, "the lockfile"
+
+		// The user's own SPs can't be downloaded
+		if localAssetPath(asset.Url) != "" {
+			if err := b.getLocalAsset(c.name, asset); err != nil {
+				return nil, err
 			}
+			continue
+		}
+
+		if err := b.getAsset(ctx, c.name, asset); err != nil {
+			return nil, err
 		}
 	}
 
-	// Extract SPs
-	if dos.sps && sps_zipfile != nil {
-		log_add(fmt.Sprintf("Extracting %s… ", filepath.Base(*sps_zipfile)))
-		if err = extractZip(*sps_zipfile, outdir); err != nil {
-			log_add(fmt.Sprintf("\n! Could not extract %s: %s\n", *sps_zipfile, err))
-		} else {
-			log_add("Done\n")
+	return locked, nil
+}
+
+/**
+ * Puts the files of a component into the output dir
+ * @param  context.Context ctx
+ * @param  *component      c
+ * @param  *LockComponent  lc     Downloaded files, nil if there's nothing to download
+ * @param  string          outdir
+ * @return error           Last error found, every file is tried anyway unless it's canceled
+ */
+func (b *Builder) install(ctx context.Context, c *component, lc *LockComponent, outdir string) error {
+	var last_err error
+
+	var files []string
+	if lc != nil {
+		for _, asset := range lc.Assets {
+			files = append(files, asset.path())
 		}
 	}
 
-	// Move DBI files
-	if dos.dbi && len(dbi_files) > 0 {
-		log_add("Moving DBI files… ")
+	switch c.install {
+	case installExtract:
+		for _, file := range files {
+			b.step(c.name, "Extracting %s", filepath.Base(file))
+			if err := b.extractZip(ctx, file, filepath.Join(outdir, c.dest), c.skip_prefix); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				b.warn(c.name, "Could not extract %s: %s", file, err)
+				last_err = err
+			} else {
+				b.stepDone(c.name)
+			}
+		}
 
-		dbi_no_errors := true
-		dbi_folder := filepath.Join(outdir, "switch", "DBI")
-		os.MkdirAll(dbi_folder, os.ModePerm)
+	case installCopy:
+		b.step(c.name, "Copying %s", c.name)
+		if err := b.copyFile(
+			filepath.Join(outdir, c.from),
+			filepath.Join(outdir, c.dest),
+		); err != nil {
+			b.warn(c.name, "Could not create %s: %s", c.dest, err)
+			last_err = err
+		} else {
+			b.stepDone(c.name)
+		}
+
+	case installMove:
+		b.step(c.name, "Moving %s files", c.name)
 
-		for _, dbi_file := range dbi_files {
-			dest_filename := filepath.Base(*dbi_file)
+		dest_folder := filepath.Join(outdir, c.dest)
+		os.MkdirAll(dest_folder, os.ModePerm)
 
-			if err = os.Rename(
-				*dbi_file,
-				filepath.Join(dbi_folder, dest_filename),
+		for _, file := range files {
+			dest_filename := filepath.Base(file)
+			if c.dest_name != "" {
+				dest_filename = c.dest_name
+			}
+
+			if err := b.moveFile(
+				file,
+				filepath.Join(dest_folder, dest_filename),
 			); err != nil {
-				dbi_no_errors = false
-				log_add(fmt.Sprintf("\n! Could not move %s: %s\n", dest_filename, err))
+				b.warn(c.name, "Could not move %s: %s", dest_filename, err)
+				last_err = err
 			}
 		}
 
-		if dbi_no_errors {
-			log_add("Done\n")
+		if last_err == nil {
+			b.stepDone(c.name)
 		}
 	}
 
-	// Set new output directory just in case
-	folder_entry_data.Set(newOutdir())
-
-	close_btn.Enable()
+	return last_err
 }
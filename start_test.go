@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/**
+ * Fake releases API with a repo for each way a component can end up:
+ * x/good has a zip, x/empty has no releases, x/noassets has a release without files and
+ * x/broken always fails
+ */
+func newReleasesServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var zip_data bytes.Buffer
+	zw := zip.NewWriter(&zip_data)
+	f, _ := zw.Create("good.nro")
+	f.Write([]byte("homebrew"))
+	zw.Close()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/repos/x/good/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"tag_name": "v1.0.0", "published_at": "2026-01-02T03:04:05Z", "assets": [{"browser_download_url": "` + srv.URL + `/download/good.zip"}]}]`))
+	})
+	mux.HandleFunc("/repos/x/empty/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	})
+	mux.HandleFunc("/repos/x/noassets/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"tag_name": "v2.0.0", "published_at": "2026-01-02T03:04:05Z", "assets": []}]`))
+	})
+	mux.HandleFunc("/repos/x/broken/releases", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/download/good.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(zip_data.Bytes())
+	})
+
+	return srv
+}
+
+/**
+ * Runs a build of the given components against the fake API
+ * @return []Event Everything the build emitted
+ * @return string  Output dir
+ * @return error   What Run returned
+ */
+func runTestBuild(t *testing.T, test_components []*component) ([]Event, string, error) {
+	t.Helper()
+
+	srv := newReleasesServer(t)
+
+	old_api_url, old_components, old_workdir := github_api_url, components, workdir
+	t.Cleanup(func() {
+		github_api_url, components, workdir = old_api_url, old_components, old_workdir
+	})
+	github_api_url, components, workdir = srv.URL, test_components, t.TempDir()
+
+	dos := dos_type{}
+	for _, c := range test_components {
+		dos[c.id] = true
+	}
+
+	var mu sync.Mutex
+	var events []Event
+	sink := EventSinkFunc(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+
+	outdir := filepath.Join(t.TempDir(), "SD")
+	err := NewBuilder(sink, &Settings{}).Run(context.Background(), dos, outdir)
+
+	return events, outdir, err
+}
+
+func testComponent(id string, repo string, required bool) *component {
+	return &component{
+		id:       id,
+		name:     strings.ToUpper(id[:1]) + id[1:],
+		required: required,
+		source:   sourceGitHub,
+		repo:     repo,
+		filter:   `\.zip$`,
+		install:  installExtract,
+		dest:     "switch/" + id,
+	}
+}
+
+/**
+ * @return map[string]*ComponentResult Results of the summary by component name
+ */
+func summaryResults(t *testing.T, events []Event) map[string]*ComponentResult {
+	t.Helper()
+
+	for _, e := range events {
+		if e.Kind == EventSummary {
+			results := map[string]*ComponentResult{}
+			for _, r := range e.Results {
+				results[r.Component] = r
+			}
+			return results
+		}
+	}
+
+	t.Fatal("no summary was emitted")
+	return nil
+}
+
+func hasWarning(events []Event, component string, text string) bool {
+	for _, e := range events {
+		if e.Kind == EventWarning && e.Component == component && strings.Contains(e.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunOptionalFailures(t *testing.T) {
+	events, outdir, err := runTestBuild(t, []*component{
+		testComponent("empty", "x/empty", false),
+		testComponent("noassets", "x/noassets", false),
+		testComponent("broken", "x/broken", false),
+		testComponent("good", "x/good", false),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	failures := map[string]string{
+		"Empty":    "no releases found",
+		"Noassets": "no matching files found",
+		"Broken":   "bad status: 500 Internal Server Error",
+	}
+
+	results := summaryResults(t, events)
+	for name, cause := range failures {
+		r := results[name]
+		if r == nil || r.Status != ResultFailed || r.Err == nil || r.Err.Error() != cause {
+			t.Errorf("%s: got %+v, want failed with %q", name, r, cause)
+		}
+		if !hasWarning(events, name, "Could not get "+name+": "+cause) {
+			t.Errorf("%s: no warning", name)
+		}
+	}
+
+	// The build went on with the rest
+	if r := results["Good"]; r == nil || r.Status != ResultOK || r.Tag != "v1.0.0" {
+		t.Errorf("Good: got %+v, want ok with v1.0.0", r)
+	}
+	if _, err := os.Stat(filepath.Join(outdir, "switch", "good", "good.nro")); err != nil {
+		t.Errorf("Good was not installed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outdir, lockfile_name)); err != nil {
+		t.Errorf("no lockfile: %v", err)
+	}
+}
+
+func TestRunRequiredFailure(t *testing.T) {
+	events, outdir, err := runTestBuild(t, []*component{
+		testComponent("good", "x/good", false),
+		testComponent("broken", "x/broken", true),
+		testComponent("empty", "x/empty", false),
+	})
+
+	want := "could not get Broken: bad status: 500 Internal Server Error"
+	if err == nil || err.Error() != want {
+		t.Fatalf("Run = %v, want %q", err, want)
+	}
+
+	fatal := false
+	for _, e := range events {
+		fatal = fatal || (e.Kind == EventFatal && e.Err == err)
+	}
+	if !fatal {
+		t.Error("no fatal event")
+	}
+
+	results := summaryResults(t, events)
+	if r := results["Broken"]; r == nil || r.Status != ResultFailed {
+		t.Errorf("Broken: got %+v, want failed", r)
+	}
+	for _, name := range []string{"Good", "Empty"} {
+		if r := results[name]; r == nil || r.Status != ResultSkipped || !errors.Is(r.Err, errNotReached) {
+			t.Errorf("%s: got %+v, want skipped", name, r)
+		}
+	}
+
+	// Nothing is installed once a required component fails
+	if _, err := os.Stat(outdir); !os.IsNotExist(err) {
+		t.Errorf("output dir was created: %v", err)
+	}
+}
+
+/**
+ * Makes a folder on another filesystem than the temp dir, where renames from it fail
+ * @return string Skips the test if there's none
+ */
+func otherFilesystemDir(t *testing.T) string {
+	t.Helper()
+
+	// Usually a tmpfs on Linux, set MAKE_NSW_SD_OTHER_FS to use something else
+	parent := os.Getenv("MAKE_NSW_SD_OTHER_FS")
+	if parent == "" {
+		parent = "/dev/shm"
+	}
+
+	dir, err := os.MkdirTemp(parent, "make-nsw-sd-test-")
+	if err != nil {
+		t.Skipf("no other filesystem: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	probe := filepath.Join(t.TempDir(), "probe")
+	os.WriteFile(probe, nil, 0644)
+	if os.Rename(probe, filepath.Join(dir, "probe")) == nil {
+		t.Skipf("%s is on the same filesystem as the temp dir", parent)
+	}
+
+	return dir
+}
+
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "DBI.nro")
+	os.WriteFile(src, []byte("new"), 0644)
+
+	dst := filepath.Join(otherFilesystemDir(t), "DBI.nro")
+	os.WriteFile(dst, []byte("old"), 0644)
+
+	b := NewBuilder(EventSinkFunc(func(Event) {}), &Settings{Conflicts: conflict_backup})
+	b.written = map[string]string{}
+	if err := b.moveFile(src, dst); err != nil {
+		t.Fatalf("moveFile: %v", err)
+	}
+
+	if data, _ := os.ReadFile(dst); string(data) != "new" {
+		t.Errorf("DBI.nro = %q, want new", data)
+	}
+	if data, _ := os.ReadFile(dst + ".bak"); string(data) != "old" {
+		t.Errorf("DBI.nro.bak = %q, want old", data)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source is still in the workdir: %v", err)
+	}
+	if b.written[dst] == "" {
+		t.Error("moved file is not in the installed files")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/**
+ * What an update needs to know about the SD card being updated
+ */
+type update_state struct {
+	root string
+	// Lockfile found on the card, nil if it wasn't made by this app
+	previous *Lockfile
+	// User-edited files of the installed components, as in component.configs
+	configs []string
+	// SHA-256 of every file written by the previous build, by slash-separated path
+	owned map[string]string
+}
+
+/**
+ * Finds out which components are on an SD card, from its lockfile and the files on it
+ * @param  string sd_root
+ * @return *Lockfile Nil if there's none
+ * @return dos_type, error
+ */
+func detectInstalled(sd_root string) (*Lockfile, dos_type, error) {
+	info, err := os.Stat(sd_root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil, errors.New("not a folder")
+	}
+
+	dos := dos_type{}
+
+	previous, err := readLockfile(filepath.Join(sd_root, lockfile_name))
+	if err == nil {
+		for _, id := range previous.Selected {
+			dos[id] = true
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("could not read %s: %s", lockfile_name, err)
+	}
+
+	// Things may have been added by hand since
+	for _, c := range components {
+		if c.detect == "" {
+			continue
+		}
+		if _, err = os.Stat(filepath.Join(sd_root, filepath.FromSlash(c.detect))); err == nil {
+			dos[c.id] = true
+		}
+	}
+
+	if len(dos) == 0 {
+		return nil, nil, errors.New("no known components found, is it the root of an SD card?")
+	}
+
+	return previous, dos, nil
+}
+
+/**
+ * Updates the components installed on an SD card in place. Files of the previous build that
+ * the new releases don't have are removed, user-edited files are kept
+ * @param  context.Context ctx
+ * @param  string          sd_root
+ * @return error
+ */
+func (b *Builder) Update(ctx context.Context, sd_root string) error {
+	previous, dos, err := detectInstalled(sd_root)
+	if err != nil {
+		return b.finish(fmt.Errorf("could not update %s: %s", sd_root, err))
+	}
+
+	var names []string
+	for _, c := range components {
+		if dos.wants(c) {
+			names = append(names, c.name)
+		}
+	}
+	b.info("", "Installed on %s: %s", sd_root, strings.Join(names, ", "))
+
+	if previous == nil {
+		b.info("", "No %s found, files of older releases can't be removed this time", lockfile_name)
+	}
+
+	b.update = &update_state{root: sd_root, previous: previous, owned: map[string]string{}}
+	defer func() {
+		b.update = nil
+	}()
+
+	for _, c := range components {
+		if dos.wants(c) {
+			b.update.configs = append(b.update.configs, c.configs...)
+		}
+	}
+	if previous != nil {
+		for _, lc := range previous.Components {
+			for _, file := range lc.Files {
+				if file.Sha256 != "" {
+					b.update.owned[file.Path] = file.Sha256
+				}
+			}
+		}
+	}
+
+	return b.finish(b.run(ctx, dos, sd_root, nil))
+}
+
+/**
+ * @param  string path Full path of a file on the card
+ * @return string Slash-separated and relative to the SD root
+ */
+func (u *update_state) relPath(path string) string {
+	rel, err := filepath.Rel(u.root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+/**
+ * @param  string rel Slash-separated path relative to the SD root
+ * @return bool
+ */
+func (u *update_state) isConfig(rel string) bool {
+	for _, config := range u.configs {
+		if rel == config || (strings.HasSuffix(config, "/") && strings.HasPrefix(rel, config)) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Decides what an update does with a file that's already on the card
+ * @param  string dst Full path
+ * @return bool   Replace it
+ * @return string Why it's kept
+ * @return string SHA-256 to keep owning it with, so it's still protected on the next update
+ * @return bool   False if it's up to the conflict policy
+ */
+func (u *update_state) decide(dst string) (bool, string, string, bool) {
+	rel := u.relPath(dst)
+
+	if u.isConfig(rel) {
+		return false, "kept, it's a user config", "", true
+	}
+
+	owned := u.owned[rel]
+	if owned == "" {
+		return false, "", "", false
+	}
+
+	// Files of the previous build are replaced unless they were changed since
+	if _, sha, err := hashFile(dst); err == nil && sha == owned {
+		return true, "", "", true
+	}
+	return false, "kept, it was changed since the last build", owned, true
+}
+
+/**
+ * Removes the files a component had in the previous build that its new release doesn't have,
+ * unless they were changed since
+ * @param  *component     c
+ * @param  *LockComponent lc Just installed
+ */
+func (b *Builder) removeStale(c *component, lc *LockComponent) {
+	if b.update.previous == nil {
+		return
+	}
+	old := b.update.previous.component(c.id)
+	if old == nil {
+		return
+	}
+
+	current := map[string]bool{}
+	for _, file := range lc.Files {
+		current[file.Path] = true
+	}
+
+	for _, file := range old.Files {
+		// Files that were already there weren't ours to begin with
+		if current[file.Path] || file.Sha256 == "" || !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			continue
+		}
+
+		path := filepath.Join(b.update.root, filepath.FromSlash(file.Path))
+
+		_, sha, err := hashFile(path)
+		if err != nil {
+			// Already gone
+			continue
+		}
+		if sha != file.Sha256 {
+			b.warn(c.name, "Kept %s, it's not in the new release but it was changed since the last build", file.Path)
+			continue
+		}
+
+		if err = os.Remove(path); err != nil {
+			b.warn(c.name, "Could not remove %s: %s", file.Path, err)
+			continue
+		}
+		b.info(c.name, "Removed %s, it's not in the new release", file.Path)
+
+		// Clean up folders left empty, removing a folder with something in it fails
+		for dir := filepath.Dir(path); dir != b.update.root; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+/**
+ * Keeps the previous lockfile entries of the components that weren't updated, so their files
+ * are still known next time
+ * @param  *Lockfile lock New lockfile
+ */
+func (u *update_state) carryOver(lock *Lockfile) {
+	if u.previous == nil {
+		return
+	}
+
+	for _, lc := range u.previous.Components {
+		if lock.component(lc.Id) != nil {
+			continue
+		}
+		lock.Components = append(lock.Components, lc)
+		if !slices.Contains(lock.Selected, lc.Id) {
+			lock.Selected = append(lock.Selected, lc.Id)
+		}
+	}
+}